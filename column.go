@@ -26,11 +26,44 @@ type ColumnMap struct {
 
 	DefaultValue string
 
+	// If true, the values bound to this column by generated statements
+	// are masked in traced and logged statements
+	Sensitive bool
+
+	// If set, used as the column type in create table statements instead
+	// of the type returned by Dialect.ToSqlType()
+	SqlType string
+
+	// If set, " check (<Check>)" is added to create table statements
+	Check string
+
+	// Stored on the column by CreateTables() if the dialect is a
+	// ColumnCommenter
+	Comment string
+
+	// If true, this column is read by Get and Select but not written by
+	// Insert, Update or Upsert, as for columns computed by the database
+	ReadOnly bool
+
 	fieldName  string
 	gotype     reflect.Type
 	isPK       bool
 	isAutoIncr bool
 	isNotNull  bool
+	isCreated  bool
+	isUpdated  bool
+	foreignKey *ForeignKeyMap
+	table      *TableMap
+
+	// Indexes declared by the tag options of the field; see
+	// TableMap.addTagIndexes.
+	tagIndexes []tagIndex
+}
+
+// tagIndex is an index declared with the index or uniqueindex tag option.
+type tagIndex struct {
+	name   string
+	unique bool
 }
 
 // Rename allows you to specify the column name in the table
@@ -38,6 +71,7 @@ type ColumnMap struct {
 // Example:  table.ColMap("Updated").Rename("date_updated")
 //
 func (c *ColumnMap) Rename(colname string) *ColumnMap {
+	c.checkFrozen("Rename")
 	c.ColumnName = colname
 	return c
 }
@@ -45,13 +79,26 @@ func (c *ColumnMap) Rename(colname string) *ColumnMap {
 // SetTransient allows you to mark the column as transient. If true
 // this column will be skipped when SQL statements are generated
 func (c *ColumnMap) SetTransient(b bool) *ColumnMap {
+	c.checkFrozen("SetTransient")
 	c.Transient = b
 	return c
 }
 
+// SetSensitive masks the values of this column in the statements traced
+// with TraceOn and logged with SetLogger, if b is true.  The "sensitive"
+// tag option has the same effect.  Only the statements generated by
+// Insert, Update, Delete, Get and the like know which arguments belong to
+// the column; use DbMap.SetRedactor to mask arguments of other queries.
+func (c *ColumnMap) SetSensitive(b bool) *ColumnMap {
+	c.checkFrozen("SetSensitive")
+	c.Sensitive = b
+	return c
+}
+
 // SetUnique adds "unique" to the create table statements for this
 // column, if b is true.
 func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
+	c.checkFrozen("SetUnique")
 	c.Unique = b
 	return c
 }
@@ -59,14 +106,69 @@ func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
 // SetNotNull adds "not null" to the create table statements for this
 // column, if nn is true.
 func (c *ColumnMap) SetNotNull(nn bool) *ColumnMap {
+	c.checkFrozen("SetNotNull")
 	c.isNotNull = nn
 	return c
 }
 
+// SetSqlType specifies the column type of "create table" statements,
+// overriding the one the dialect derives from the field type.
+func (c *ColumnMap) SetSqlType(sqlType string) *ColumnMap {
+	c.checkFrozen("SetSqlType")
+	c.SqlType = sqlType
+	return c
+}
+
+// SetCheck adds the check constraint expr to the create table statements
+// for this column.
+func (c *ColumnMap) SetCheck(expr string) *ColumnMap {
+	c.checkFrozen("SetCheck")
+	c.Check = expr
+	return c
+}
+
+// SetComment specifies the comment CreateTables stores on the column, if
+// the dialect supports column comments.
+func (c *ColumnMap) SetComment(comment string) *ColumnMap {
+	c.checkFrozen("SetComment")
+	c.Comment = comment
+	return c
+}
+
+// SetReadOnly keeps the column out of the statements of Insert, Update
+// and Upsert if b is true, while Get and Select still read it.
+func (c *ColumnMap) SetReadOnly(b bool) *ColumnMap {
+	c.checkFrozen("SetReadOnly")
+	c.ReadOnly = b
+	return c
+}
+
+// sqlType returns the type of the column in create table statements.
+func (c *ColumnMap) sqlType(dialect Dialect) string {
+	if c.SqlType != "" {
+		return c.SqlType
+	}
+	return dialect.ToSqlType(c.gotype, c.MaxSize, c.isAutoIncr)
+}
+
 // SetMaxSize specifies the max length of values of this column. This is
 // passed to the dialect.ToSqlType() function, which can use the value
 // to alter the generated type for "create table" statements
 func (c *ColumnMap) SetMaxSize(size int) *ColumnMap {
+	c.checkFrozen("SetMaxSize")
 	c.MaxSize = size
 	return c
 }
+
+// SetForeignKey makes the column reference refColumn of refTable, and
+// returns the ForeignKeyMap to set its actions on.  refTable is the name
+// of the referenced table; qualify it as "schema.table" if it is not in
+// the default schema.  Use TableMap.AddForeignKey for foreign keys over
+// several columns.
+func (c *ColumnMap) SetForeignKey(refTable, refColumn string) *ForeignKeyMap {
+	c.checkFrozen("SetForeignKey")
+	c.foreignKey = newForeignKeyMap(refTable, []string{refColumn})
+	c.foreignKey.table = c.table
+	c.foreignKey.columns = []*ColumnMap{c}
+	return c.foreignKey
+}
@@ -2,6 +2,7 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,44 +32,41 @@ type DbMap struct {
 
 	TypeConverter TypeConverter
 
-	tables    []*TableMap
-	logger    GorpLogger
-	logPrefix string
+	// Clock provides the time of created, updated and soft delete
+	// columns.  time.Now is used if it is nil.
+	Clock Clock
+
+	tables        []*TableMap
+	logger        GorpLogger
+	logPrefix     string
+	structLogger  StructuredLogger
+	slowThreshold time.Duration
+	ctx           context.Context
+	withDeleted   bool
+	stmtTable     *TableMap
+	stmtOp        string
+	stmtSensitive []bool
+	interceptors  []Interceptor
+	redactor      Redactor
+	metrics       MetricsCollector
+	stmtCache     *stmtCache
+	frozen        bool
+	replicas      []*sql.DB
+	balancer      Balancer
+	onPrimary     bool
+
+	// Functions registered with OnCommit and OnRollback by the hooks of
+	// the Insert, Update or the like the DbMap was copied for, kept until
+	// its outcome is known.
+	pending *txState
 }
 
 func (m *DbMap) CreateIndex() error {
 
 	var err error
-	dialect := reflect.TypeOf(m.Dialect)
 	for _, table := range m.tables {
 		for _, index := range table.indexes {
-
-			s := bytes.Buffer{}
-			s.WriteString("create")
-			if index.Unique {
-				s.WriteString(" unique")
-			}
-			s.WriteString(" index")
-			s.WriteString(fmt.Sprintf(" %s on %s", index.IndexName,
-				table.TableName))
-			if dname := dialect.Name(); dname == "PostgresDialect" && index.IndexType != "" {
-				s.WriteString(fmt.Sprintf(" %s %s", m.Dialect.CreateIndexSuffix(), index.IndexType))
-			}
-			s.WriteString(" (")
-			x := 0
-			for _, col := range index.columns {
-				if x > 0 {
-					s.WriteString(", ")
-				}
-				s.WriteString(m.Dialect.QuoteField(col))
-			}
-			s.WriteString(")")
-
-			if dname := dialect.Name(); dname == "MySQLDialect" && index.IndexType != "" {
-				s.WriteString(fmt.Sprintf(" %s %s", m.Dialect.CreateIndexSuffix(), index.IndexType))
-			}
-			s.WriteString(";")
-			_, err = m.Exec(s.String())
+			_, err = m.Exec(table.sqlForCreateIndex(index))
 			if err != nil {
 				break
 			}
@@ -76,6 +75,37 @@ func (m *DbMap) CreateIndex() error {
 	return err
 }
 
+// sqlForCreateIndex returns the "create index" statement for an index of
+// the table.
+func (t *TableMap) sqlForCreateIndex(index *IndexMap) string {
+	s := bytes.Buffer{}
+	dialect := reflect.TypeOf(t.dbmap.Dialect)
+	s.WriteString("create")
+	if index.Unique {
+		s.WriteString(" unique")
+	}
+	s.WriteString(" index")
+	s.WriteString(fmt.Sprintf(" %s on %s", index.IndexName,
+		t.TableName))
+	if dname := dialect.Name(); dname == "PostgresDialect" && index.IndexType != "" {
+		s.WriteString(fmt.Sprintf(" %s %s", t.dbmap.Dialect.CreateIndexSuffix(), index.IndexType))
+	}
+	s.WriteString(" (")
+	for x, col := range index.columns {
+		if x > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(t.dbmap.Dialect.QuoteField(col))
+	}
+	s.WriteString(")")
+
+	if dname := dialect.Name(); dname == "MySQLDialect" && index.IndexType != "" {
+		s.WriteString(fmt.Sprintf(" %s %s", t.dbmap.Dialect.CreateIndexSuffix(), index.IndexType))
+	}
+	s.WriteString(";")
+	return s.String()
+}
+
 func (t *TableMap) DropIndex(name string) error {
 
 	var err error
@@ -120,6 +150,7 @@ func (m *DbMap) AddTableWithName(i interface{}, name string) *TableMap {
 // AddTableWithNameAndSchema has the same behavior as AddTable, but sets
 // table.TableName to name.
 func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name string) *TableMap {
+	m.checkFrozen("AddTable")
 	t := reflect.TypeOf(i)
 	if name == "" {
 		name = t.Name()
@@ -142,10 +173,43 @@ func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name str
 	if len(primaryKey) > 0 {
 		tmap.keys = append(tmap.keys, primaryKey...)
 	}
+	for _, col := range tmap.Columns {
+		col.table = tmap
+		if col.isCreated {
+			tmap.SetCreatedCol(col.fieldName)
+		}
+		if col.isUpdated {
+			tmap.SetUpdatedCol(col.fieldName)
+		}
+	}
+	tmap.addTagIndexes()
 
 	return tmap
 }
 
+// splitTag splits a db tag into the column name and its options at the
+// commas outside parentheses and single quotes, so that options such as
+// "type:decimal(10,2)" and "check:state in ('a','b')" can contain commas.
+func splitTag(tag string) []string {
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i, r := range tag {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, tag[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tag[start:])
+}
+
 func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey []*ColumnMap) {
 	primaryKey = make([]*ColumnMap, 0)
 	n := t.NumField()
@@ -174,23 +238,30 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 		} else {
 			// Tag = Name { ','  Option }
 			// Option = OptionKey [ ':' OptionValue ]
-			cArguments := strings.Split(f.Tag.Get("db"), ",")
+			cArguments := splitTag(f.Tag.Get("db"))
 			columnName := cArguments[0]
 			var maxSize int
 			var defaultValue string
 			var isAuto bool
 			var isPK bool
+			var isCreated, isUpdated bool
+			var isSensitive bool
+			var isNotNull, isUnique, isReadOnly, isTransient bool
+			var sqlType, check, comment string
+			var indexes []tagIndex
 			for _, argString := range cArguments[1:] {
 				argString = strings.TrimSpace(argString)
 				arg := strings.SplitN(argString, ":", 2)
 
 				// check mandatory/unexpected option values
 				switch arg[0] {
-				case "size", "default":
+				case "size", "default", "type", "check", "comment":
 					// options requiring value
 					if len(arg) == 1 {
 						panic(fmt.Sprintf("missing option value for option %v on field %v", arg[0], f.Name))
 					}
+				case "index", "uniqueindex":
+					// options with an optional value
 				default:
 					// options where value is invalid (currently all other options)
 					if len(arg) == 2 {
@@ -207,6 +278,32 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 					isPK = true
 				case "autoincrement":
 					isAuto = true
+				case "created":
+					isCreated = true
+				case "updated":
+					isUpdated = true
+				case "sensitive":
+					isSensitive = true
+				case "notnull":
+					isNotNull = true
+				case "unique":
+					isUnique = true
+				case "index", "uniqueindex":
+					index := tagIndex{unique: arg[0] == "uniqueindex"}
+					if len(arg) == 2 {
+						index.name = arg[1]
+					}
+					indexes = append(indexes, index)
+				case "type":
+					sqlType = arg[1]
+				case "check":
+					check = arg[1]
+				case "comment":
+					comment = arg[1]
+				case "readonly":
+					isReadOnly = true
+				case "transient":
+					isTransient = true
 				default:
 					panic(fmt.Sprintf("Unrecognized tag option for field %v: %v", f.Name, arg))
 				}
@@ -241,12 +338,22 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 			cm := &ColumnMap{
 				ColumnName:   columnName,
 				DefaultValue: defaultValue,
-				Transient:    columnName == "-",
+				Transient:    columnName == "-" || isTransient,
+				Unique:       isUnique,
+				Sensitive:    isSensitive,
+				SqlType:      sqlType,
+				Check:        check,
+				Comment:      comment,
+				ReadOnly:     isReadOnly,
 				fieldName:    f.Name,
 				gotype:       gotype,
 				isPK:         isPK,
 				isAutoIncr:   isAuto,
+				isNotNull:    isNotNull,
+				isCreated:    isCreated,
+				isUpdated:    isUpdated,
 				MaxSize:      maxSize,
+				tagIndexes:   indexes,
 			}
 			if isPK {
 				primaryKey = append(primaryKey, cm)
@@ -287,16 +394,63 @@ func (m *DbMap) CreateTablesIfNotExists() error {
 }
 
 func (m *DbMap) createTables(ifNotExists bool) error {
-	var err error
-	for i := range m.tables {
-		table := m.tables[i]
-		sql := table.SqlForCreate(ifNotExists)
-		_, err = m.Exec(sql)
+	tables, cycles := dependencyOrder(m.tables)
+	alterer, ok := m.Dialect.(ForeignKeyAlterer)
+	if !ok {
+		cycles = nil
+	}
+
+	// foreign keys of tables referencing each other are added once all
+	// tables exist
+	var deferred []*ForeignKeyMap
+	for _, table := range tables {
+		var fks []*ForeignKeyMap
+		for _, fk := range table.ForeignKeys() {
+			if cycles[fk] {
+				fks = append(fks, fk)
+			}
+		}
+		if len(fks) > 0 && ifNotExists {
+			exists, err := m.tableExists(table)
+			if err != nil {
+				return err
+			}
+			if exists {
+				fks = nil
+			}
+		}
+		deferred = append(deferred, fks...)
+
+		_, err := m.Exec(table.sqlForCreate(ifNotExists, cycles))
 		if err != nil {
-			break
+			return err
+		}
+		for _, sql := range table.sqlForComments() {
+			_, err = m.Exec(sql)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	return err
+	for _, fk := range deferred {
+		table := m.Dialect.QuotedTableForQuery(fk.table.SchemaName, fk.table.TableName)
+		_, err := m.Exec(alterer.AddForeignKey(table, fk.sqlForConstraint()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExists reports whether the table exists, or false if the dialect
+// cannot tell.
+func (m *DbMap) tableExists(table *TableMap) (bool, error) {
+	introspector, ok := m.Dialect.(SchemaIntrospector)
+	if !ok {
+		return false, nil
+	}
+	cols, err := introspector.Columns(m, table.SchemaName, table.TableName)
+	return len(cols) > 0, err
 }
 
 // DropTable drops an individual table.  Will throw an error
@@ -325,12 +479,38 @@ func (m *DbMap) DropTablesIfExists() error {
 	return m.dropTables(true)
 }
 
-// Goes through all the registered tables, dropping them one by one.
+// Goes through all the registered tables, dropping them one by one,
+// referencing tables before the tables they reference.  The foreign keys
+// of tables referencing each other are dropped first.
 // If an error is encountered, then it is returned and the rest of
 // the tables are not dropped.
 func (m *DbMap) dropTables(addIfExists bool) (err error) {
-	for _, table := range m.tables {
-		err = m.dropTableImpl(table, addIfExists)
+	tables, cycles := dependencyOrder(m.tables)
+	if alterer, ok := m.Dialect.(ForeignKeyAlterer); ok {
+		for _, table := range tables {
+			for _, fk := range table.ForeignKeys() {
+				if !cycles[fk] {
+					continue
+				}
+				if addIfExists {
+					exists, err := m.tableExists(table)
+					if err != nil {
+						return err
+					}
+					if !exists {
+						break
+					}
+				}
+				quoted := m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)
+				_, err = m.Exec(alterer.DropForeignKey(quoted, fk.name()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		err = m.dropTableImpl(tables[i], addIfExists)
 		if err != nil {
 			return
 		}
@@ -363,8 +543,9 @@ func (m *DbMap) dropTableImpl(table *TableMap, ifExists bool) (err error) {
 // (http://www.sqlite.org/lang_delete.html)
 func (m *DbMap) TruncateTables() error {
 	var err error
-	for i := range m.tables {
-		table := m.tables[i]
+	tables, _ := dependencyOrder(m.tables)
+	for i := len(tables) - 1; i >= 0; i-- {
+		table := tables[i]
 		_, e := m.Exec(fmt.Sprintf("%s %s;", m.Dialect.TruncateClause(), m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName)))
 		if e != nil {
 			err = e
@@ -384,7 +565,54 @@ func (m *DbMap) TruncateTables() error {
 //
 // Panics if any interface in the list has not been registered with AddTable
 func (m *DbMap) Insert(list ...interface{}) error {
-	return insert(m, m, list...)
+	op, done := m.operation()
+	return done(insert(op, op, list...))
+}
+
+// InsertBatch has the same behavior as Insert, but groups the elements of
+// list by TableMap and inserts each group with multi-row
+// "insert into ... values (...), (...)" statements.  Each statement is
+// kept within the bind variable limit of the dialect, so large lists are
+// split across several statements.
+//
+// Auto-increment keys are bound back to the elements when the dialect
+// implements BatchAutoIncrInserter.  Tables with an auto-increment key on
+// other dialects, and all tables on dialects that do not implement
+// BatchInserter, are inserted one row at a time.
+//
+// The keys are matched to the elements by position, which relies on the
+// database generating them in the order the rows are listed: PostgreSQL
+// is assumed to return them from "returning" in that order, although it
+// does not document it, and SQLite and MySQL to assign consecutive keys.
+// Use Insert where a wrong match cannot be afforded.
+//
+// The PreInsert() hooks of a statement's elements all run before it is
+// executed, and the PostInsert() hooks after.
+func (m *DbMap) InsertBatch(list ...interface{}) error {
+	op, done := m.operation()
+	return done(insertBatch(op, op, list...))
+}
+
+// Upsert inserts each element in list, or updates the existing row when
+// one with the same unique key is already present, using a single
+// dialect-specific statement per element.  List items must be pointers.
+//
+// Existing rows are matched on the primary key, or on the first
+// SetUniqueTogether constraint (or unique column) if the primary key is
+// auto-incremented.  All other columns are updated, and the version
+// column, if any, is incremented.  The created column, if any, keeps the
+// value of an existing row.  Afterwards the auto-increment key, the
+// version column and the created column are read back into the struct.
+//
+// The hook functions PreInsert() and PreUpdate() are both executed
+// before the statement if the interface defines them.  Post hooks are
+// not run, as the statement does not report which of the two happened.
+//
+// Returns an error if the dialect does not implement UpsertDialect.
+// Panics if any interface in the list has not been registered with AddTable
+func (m *DbMap) Upsert(list ...interface{}) error {
+	op, done := m.operation()
+	return done(upsert(op, op, list...))
 }
 
 // Update runs a SQL UPDATE statement for each element in list.  List
@@ -398,7 +626,9 @@ func (m *DbMap) Insert(list ...interface{}) error {
 // Returns an error if SetKeys has not been called on the TableMap
 // Panics if any interface in the list has not been registered with AddTable
 func (m *DbMap) Update(list ...interface{}) (int64, error) {
-	return update(m, m, list...)
+	op, done := m.operation()
+	n, err := update(op, op, list...)
+	return n, done(err)
 }
 
 // Delete runs a SQL DELETE statement for each element in list.  List
@@ -412,7 +642,29 @@ func (m *DbMap) Update(list ...interface{}) (int64, error) {
 // Returns an error if SetKeys has not been called on the TableMap
 // Panics if any interface in the list has not been registered with AddTable
 func (m *DbMap) Delete(list ...interface{}) (int64, error) {
-	return delete(m, m, list...)
+	op, done := m.operation()
+	n, err := delete(op, op, list...)
+	return n, done(err)
+}
+
+// HardDelete has the same behavior as Delete, but deletes the rows of
+// tables with a soft delete column for good.  See
+// TableMap.SetSoftDeleteCol.
+func (m *DbMap) HardDelete(list ...interface{}) (int64, error) {
+	op, done := m.operation()
+	n, err := hardDelete(op, op, list...)
+	return n, done(err)
+}
+
+// Restore clears the soft delete marker of each element in list, and
+// increments its version column if the table has one.  List items must
+// be pointers.  No hooks are run.
+//
+// Returns the number of rows restored.
+//
+// Returns an error if a table has no soft delete column.
+func (m *DbMap) Restore(list ...interface{}) (int64, error) {
+	return restore(m, m, list...)
 }
 
 // Get runs a SQL SELECT to fetch a single row from the table based on the
@@ -457,12 +709,46 @@ func (m *DbMap) Select(i interface{}, query string, args ...interface{}) ([]inte
 	return hookedselect(m, m, i, query, args...)
 }
 
+// Query starts building a query against the table mapped to i.  See
+// Query for details.
+func (m *DbMap) Query(i interface{}) *Query {
+	return newQuery(m, m, i)
+}
+
+// Preload loads the named relations of the structs in list, which may
+// be a pointer to a struct, a slice of structs or pointers to them, a
+// pointer to such a slice, or the result of Select.  Relations are
+// declared with TableMap.HasOne, HasMany and BelongsTo; nested relations
+// of the loaded records are named with dots, as in "Tags.Person".
+//
+// Each relation is loaded with a single "where key in (...)" query for
+// all of list, so
+//
+//	invoices, err := dbmap.Select(Invoice{}, "select * from invoice_test")
+//	err = dbmap.Preload(invoices, "Tags", "Person")
+//
+// runs three queries in total.
+func (m *DbMap) Preload(list interface{}, relations ...string) error {
+	return preload(m, m, list, relations)
+}
+
+// SelectIter runs an arbitrary SQL query and returns an iterator over
+// the results, which are decoded one at a time into values of the type
+// of i.  i may be a struct, a pointer to one, or a non-struct type for
+// single-column queries.  See SelectIterator.
+//
+// As with Select, a NoFieldInTypeError is returned along with the
+// iterator if the query returns columns that are not in the struct.
+func (m *DbMap) SelectIter(i interface{}, query string, args ...interface{}) (*SelectIterator, error) {
+	return selectIter(m, m, i, query, args...)
+}
+
 // Exec runs an arbitrary SQL statement.  args represent the bind parameters.
 // This is equivalent to running:  Exec() using database/sql
 func (m *DbMap) Exec(query string, args ...interface{}) (sql.Result, error) {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, query, args...)
+		defer m.trace(now, query, m.logArgs(query, m.stmtSensitive, args)...)
 	}
 	return exec(m, query, args...)
 }
@@ -502,17 +788,214 @@ func (m *DbMap) SelectOne(holder interface{}, query string, args ...interface{})
 	return SelectOne(m, m, holder, query, args...)
 }
 
+// InsertContext has the same behavior as Insert, but runs with ctx.
+func (m *DbMap) InsertContext(ctx context.Context, list ...interface{}) error {
+	return m.WithContext(ctx).Insert(list...)
+}
+
+// InsertBatchContext has the same behavior as InsertBatch, but runs with ctx.
+func (m *DbMap) InsertBatchContext(ctx context.Context, list ...interface{}) error {
+	return m.WithContext(ctx).(*DbMap).InsertBatch(list...)
+}
+
+// UpsertContext has the same behavior as Upsert, but runs with ctx.
+func (m *DbMap) UpsertContext(ctx context.Context, list ...interface{}) error {
+	return m.WithContext(ctx).(*DbMap).Upsert(list...)
+}
+
+// UpdateContext has the same behavior as Update, but runs with ctx.
+func (m *DbMap) UpdateContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return m.WithContext(ctx).Update(list...)
+}
+
+// DeleteContext has the same behavior as Delete, but runs with ctx.
+func (m *DbMap) DeleteContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return m.WithContext(ctx).Delete(list...)
+}
+
+// HardDeleteContext has the same behavior as HardDelete, but runs with ctx.
+func (m *DbMap) HardDeleteContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return m.WithContext(ctx).(*DbMap).HardDelete(list...)
+}
+
+// RestoreContext has the same behavior as Restore, but runs with ctx.
+func (m *DbMap) RestoreContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return restore(m, m.WithContext(ctx), list...)
+}
+
+// GetContext has the same behavior as Get, but runs with ctx.
+func (m *DbMap) GetContext(ctx context.Context, i interface{}, keys ...interface{}) (interface{}, error) {
+	return m.WithContext(ctx).Get(i, keys...)
+}
+
+// SelectContext has the same behavior as Select, but runs with ctx.
+func (m *DbMap) SelectContext(ctx context.Context, i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return m.WithContext(ctx).Select(i, query, args...)
+}
+
+// SelectIterContext has the same behavior as SelectIter, but runs with ctx.
+func (m *DbMap) SelectIterContext(ctx context.Context, i interface{}, query string, args ...interface{}) (*SelectIterator, error) {
+	return m.WithContext(ctx).SelectIter(i, query, args...)
+}
+
+// ExecContext has the same behavior as Exec, but runs with ctx.
+func (m *DbMap) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return m.WithContext(ctx).Exec(query, args...)
+}
+
+// SelectIntContext has the same behavior as SelectInt, but runs with ctx.
+func (m *DbMap) SelectIntContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return m.WithContext(ctx).SelectInt(query, args...)
+}
+
+// SelectNullIntContext has the same behavior as SelectNullInt, but runs with ctx.
+func (m *DbMap) SelectNullIntContext(ctx context.Context, query string, args ...interface{}) (sql.NullInt64, error) {
+	return m.WithContext(ctx).SelectNullInt(query, args...)
+}
+
+// SelectFloatContext has the same behavior as SelectFloat, but runs with ctx.
+func (m *DbMap) SelectFloatContext(ctx context.Context, query string, args ...interface{}) (float64, error) {
+	return m.WithContext(ctx).SelectFloat(query, args...)
+}
+
+// SelectNullFloatContext has the same behavior as SelectNullFloat, but runs with ctx.
+func (m *DbMap) SelectNullFloatContext(ctx context.Context, query string, args ...interface{}) (sql.NullFloat64, error) {
+	return m.WithContext(ctx).SelectNullFloat(query, args...)
+}
+
+// SelectStrContext has the same behavior as SelectStr, but runs with ctx.
+func (m *DbMap) SelectStrContext(ctx context.Context, query string, args ...interface{}) (string, error) {
+	return m.WithContext(ctx).SelectStr(query, args...)
+}
+
+// SelectNullStrContext has the same behavior as SelectNullStr, but runs with ctx.
+func (m *DbMap) SelectNullStrContext(ctx context.Context, query string, args ...interface{}) (sql.NullString, error) {
+	return m.WithContext(ctx).SelectNullStr(query, args...)
+}
+
+// SelectOneContext has the same behavior as SelectOne, but runs with ctx.
+func (m *DbMap) SelectOneContext(ctx context.Context, holder interface{}, query string, args ...interface{}) error {
+	return m.WithContext(ctx).SelectOne(holder, query, args...)
+}
+
 // Begin starts a gorp Transaction
 func (m *DbMap) Begin() (*Transaction, error) {
+	return m.BeginTx(m.Context(), nil)
+}
+
+// BeginTx starts a gorp Transaction bound to ctx.  The transaction is
+// rolled back by database/sql if ctx is done before Commit is called,
+// and every statement run through the Transaction uses ctx unless it is
+// replaced with Transaction.WithContext.
+//
+// opts may be nil, in which case the driver defaults are used.  Its
+// Isolation level and ReadOnly flag are passed to the driver, unless the
+// dialect is a TxOptionsDialect setting them with statements of its own:
+//
+//	trans, err := dbmap.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+//
+// A TxBeginner dialect may also begin the transaction with a statement of
+// its own, as SQLite does with "begin immediate" for writable
+// transactions given an isolation level.  Such a transaction is not
+// rolled back when ctx is done, so it must be ended with Rollback.
+func (m *DbMap) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
 	if m.logger != nil {
 		now := time.Now()
 		defer m.trace(now, "begin;")
 	}
-	tx, err := m.Db.Begin()
+	id := atomic.AddUint64(&lastTxID, 1)
+	started := time.Now()
+	tx, conn, reset, err := m.beginTx(ctx, id, opts)
+	err = m.classifyError(err)
+	if m.structLogger != nil {
+		m.logEvent(ctx, id, "begin", time.Since(started), err, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{m, tx, false}, nil
+	return &Transaction{dbmap: m, tx: tx, id: id, ctx: ctx, conn: conn, reset: reset,
+		state: newTxState(nil)}, nil
+}
+
+// lastTxID is the id of the last Transaction begun.
+var lastTxID uint64
+
+// OnCommit registers fn to run once the Insert, Update or the like whose
+// hooks call it has succeeded.  fn is dropped if the operation fails.
+// This lets hooks defer side effects with their SqlExecutor whether or
+// not it is a Transaction.  Outside of a hook, fn runs at once.  See
+// Transaction.OnCommit.
+func (m *DbMap) OnCommit(fn func()) {
+	if m.pending == nil {
+		fn()
+		return
+	}
+	m.pending.onCommit = append(m.pending.onCommit, fn)
+}
+
+// OnRollback registers fn to run once the Insert, Update or the like
+// whose hooks call it has failed.  Statements run through the DbMap are
+// not rolled back, so rows written before the failure remain.  Outside
+// of a hook, fn is dropped.  See Transaction.OnRollback.
+func (m *DbMap) OnRollback(fn func()) {
+	if m.pending != nil {
+		m.pending.onRollback = append(m.pending.onRollback, fn)
+	}
+}
+
+// operation returns the DbMap to run an Insert, Update or the like with,
+// whose OnCommit and OnRollback functions are kept until done is given
+// the outcome.  Operations run by the hooks of another share its
+// functions, which wait for the outermost operation.
+func (m *DbMap) operation() (op *DbMap, done func(error) error) {
+	if m.pending != nil {
+		return m, func(err error) error { return err }
+	}
+	op = &DbMap{}
+	*op = *m
+	op.pending = newTxState(nil)
+	return op, func(err error) error {
+		if err != nil {
+			op.pending.rolledBack()
+		} else {
+			op.pending.committed()
+		}
+		return err
+	}
+}
+
+// WithContext returns a shallow copy of the DbMap that runs every statement
+// with ctx.  It is cheap to create one per request.  Hooks triggered
+// through the copy receive it as their SqlExecutor.
+//
+// The copy is a snapshot of the configuration of m: it uses the Db,
+// Dialect and TableMaps of m, but tables, interceptors, loggers, replicas
+// and caches set on m afterwards, as well as a later Freeze, do not apply
+// to it.  Configure m before taking copies.
+func (m *DbMap) WithContext(ctx context.Context) SqlExecutor {
+	dup := &DbMap{}
+	*dup = *m
+	dup.ctx = ctx
+	return dup
+}
+
+// WithDeleted returns a shallow copy of the DbMap whose Get and Query
+// include soft deleted rows.  See TableMap.SetSoftDeleteCol.  Like the one
+// of WithContext, the copy is a snapshot of the configuration of m.
+func (m *DbMap) WithDeleted() SqlExecutor {
+	dup := &DbMap{}
+	*dup = *m
+	dup.withDeleted = true
+	return dup
+}
+
+// Context returns the context statements are run with.  Unless the DbMap
+// was created with WithContext, this is context.Background().
+func (m *DbMap) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
 }
 
 // TableFor returns the *TableMap corresponding to the given Go Type
@@ -541,7 +1024,17 @@ func (m *DbMap) Prepare(query string) (*sql.Stmt, error) {
 		now := time.Now()
 		defer m.trace(now, query, nil)
 	}
-	return m.Db.Prepare(query)
+	return m.Db.PrepareContext(m.Context(), query)
+}
+
+// PrepareContext has the same behavior as Prepare, but prepares the
+// statement with ctx.
+func (m *DbMap) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if m.logger != nil {
+		now := time.Now()
+		defer m.trace(now, query, nil)
+	}
+	return m.Db.PrepareContext(ctx, query)
 }
 
 func tableOrNil(m *DbMap, t reflect.Type) *TableMap {
@@ -571,20 +1064,21 @@ func (m *DbMap) tableForPointer(ptr interface{}, checkPK bool) (*TableMap, refle
 	return t, elem, nil
 }
 
-func (m *DbMap) queryRow(query string, args ...interface{}) *sql.Row {
+func (m *DbMap) queryRow(query string, args ...interface{}) row {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, query, args...)
+		defer m.trace(now, query, m.logArgs(query, m.stmtSensitive, args)...)
 	}
-	return m.Db.QueryRow(query, args...)
+	return m.resultRow(runStatement(m, QueryRowStatement, query, args))
 }
 
 func (m *DbMap) query(query string, args ...interface{}) (*sql.Rows, error) {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, query, args...)
+		defer m.trace(now, query, m.logArgs(query, m.stmtSensitive, args)...)
 	}
-	return m.Db.Query(query, args...)
+	res := runStatement(m, QueryStatement, query, args)
+	return res.Rows, res.Err
 }
 
 func (m *DbMap) trace(started time.Time, query string, args ...interface{}) {
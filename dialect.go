@@ -1,6 +1,11 @@
 package gorp
 
-import "reflect"
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
 
 // The Dialect interface encapsulates behaviors that differ across
 // SQL databases.  At present the Dialect is only used by CreateTables()
@@ -91,6 +96,42 @@ type TargetQueryInserter interface {
 	InsertQueryToTarget(exec SqlExecutor, insertSql, idSql string, target interface{}, params ...interface{}) error
 }
 
+// BatchInserter is implemented by dialects that support multi-row
+// "insert into ... values (...), (...)" statements.  DbMap.InsertBatch
+// falls back to one INSERT per row for dialects that do not implement it.
+type BatchInserter interface {
+	// MaxBindVars returns the maximum number of bind variables the
+	// database accepts in a single statement.
+	MaxBindVars() int
+
+	// MaxInsertRows returns the maximum number of rows accepted by a
+	// single multi-row insert, or 0 if only MaxBindVars applies.
+	MaxInsertRows() int
+}
+
+// BatchAutoIncrInserter is implemented by BatchInserter dialects that can
+// bind the keys generated by a multi-row insert back to the inserted rows.
+type BatchAutoIncrInserter interface {
+	// InsertBatchAutoIncr runs a multi-row insert and assigns the
+	// generated keys, in row order, to targets.  Each target is a pointer
+	// to the auto-increment field of one of the rows being inserted.
+	InsertBatchAutoIncr(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error
+}
+
+// UpsertDialect is implemented by dialects that can insert a row, or
+// update the existing row with the same unique key, in a single statement.
+type UpsertDialect interface {
+	// UpsertQuery returns a statement that inserts values into cols of
+	// the table.  If a row with the same values in the conflict columns
+	// exists, the update columns of that row are set to their inserted
+	// values instead.  values holds the SQL expression inserted into each
+	// column, usually a bind variable.
+	//
+	// version is nil, or the optimistic locking column.  On update it is
+	// incremented rather than set to its inserted value.
+	UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string
+}
+
 func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interface{}) (int64, error) {
 	res, err := exec.Exec(insertSql, params...)
 	if err != nil {
@@ -98,3 +139,136 @@ func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interf
 	}
 	return res.LastInsertId()
 }
+
+// standardInsertBatchAutoIncr runs a multi-row insert and assigns
+// consecutive ids to targets, based on the result's LastInsertId.  Some
+// databases report the id of the first row inserted, others the id of the
+// last one; lastIsFirst selects between the two.
+func standardInsertBatchAutoIncr(exec SqlExecutor, insertSql string, lastIsFirst bool, targets []interface{}, params ...interface{}) error {
+	id, err := standardInsertAutoIncr(exec, insertSql, params...)
+	if err != nil {
+		return err
+	}
+	if !lastIsFirst {
+		id -= int64(len(targets) - 1)
+	}
+	for i, target := range targets {
+		if !setIntValue(reflect.ValueOf(target).Elem(), id+int64(i)) {
+			return fmt.Errorf("gorp: Cannot set autoincrement value on non-Int field %v", reflect.TypeOf(target).Elem())
+		}
+	}
+	return nil
+}
+
+// setIntValue sets an integer or unsigned integer value to v, returning
+// false if v has any other kind.
+func setIntValue(v reflect.Value, i int64) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(i))
+	default:
+		return false
+	}
+	return true
+}
+
+// TxOptionsDialect is implemented by dialects that give transactions
+// their isolation level and read-only access mode with statements, for
+// drivers that do not support sql.TxOptions.  Such transactions run on a
+// connection of their own.
+type TxOptionsDialect interface {
+	// TxOptionsStatements returns the statements run on the connection
+	// before the transaction begins, and those restoring its settings
+	// once the transaction ended.  It returns an error if the database
+	// does not support the isolation level or access mode.
+	TxOptionsStatements(isolation sql.IsolationLevel, readOnly bool) (setup, reset []string, err error)
+}
+
+// TxBeginner is implemented by TxOptionsDialect dialects that begin some
+// transactions with a statement of their own rather than the driver's,
+// such as the "begin immediate" of SQLite.  Such transactions are
+// committed and rolled back with statements on their connection too.
+type TxBeginner interface {
+	// BeginStatement returns the statement beginning a transaction, or ""
+	// to let the driver begin it.
+	BeginStatement(isolation sql.IsolationLevel, readOnly bool) string
+}
+
+// RetryClassifier is implemented by dialects that recognize the errors
+// of transactions failing because of concurrent transactions, such as
+// serialization failures and deadlocks.  DbMap.InTransaction retries
+// transactions failing with these errors.
+type RetryClassifier interface {
+	IsRetryable(err error) bool
+}
+
+// ErrorClassifier is implemented by dialects that map the error codes of
+// their database onto the errors gorp classifies, such as
+// ErrUniqueViolation.  ClassifyError returns nil for other errors.
+type ErrorClassifier interface {
+	ClassifyError(err error) *DbError
+}
+
+// ColumnCommenter is implemented by dialects that store the comments of
+// columns set with the "comment" tag option or ColumnMap.SetComment.
+// Table and column names are passed quoted.
+type ColumnCommenter interface {
+	// ColumnCommentClause returns the clause giving a column its comment
+	// in create table, or "" if the dialect uses CommentOnColumn.
+	ColumnCommentClause(comment string) string
+
+	// CommentOnColumn returns the statement giving an existing column its
+	// comment, or "" if the dialect uses ColumnCommentClause.
+	CommentOnColumn(table, column, comment string) string
+}
+
+// quoteLiteral returns s as a single quoted SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// ColumnAlterer is implemented by dialects that can change the columns
+// and constraints of existing tables.  Table and column names are passed
+// quoted.  Each method returns an empty string if the dialect cannot make
+// the change.
+type ColumnAlterer interface {
+	// AddColumn returns a statement adding a column, given its
+	// definition as written in "create table".
+	AddColumn(table, definition string) string
+
+	DropColumn(table, column string) string
+
+	// AlterColumn returns a statement changing the type and nullability
+	// of a column.
+	AlterColumn(table, column, sqlType string, notNull bool) string
+
+	// AddUnique returns a statement adding a unique constraint over
+	// columns, named name.
+	AddUnique(table, name string, columns []string) string
+}
+
+// ForeignKeyAlterer is implemented by dialects that can add and drop the
+// foreign keys of existing tables.  CreateTables adds the foreign keys of
+// tables referencing each other once all of them exist, and DropTables
+// drops these foreign keys first.  Without it, such foreign keys are
+// written in "create table", which only dialects accepting references to
+// tables that do not exist yet, such as SQLite, support.  Table names are
+// passed quoted.
+type ForeignKeyAlterer interface {
+	// AddForeignKey returns a statement adding a constraint, given its
+	// definition as written in "create table".
+	AddForeignKey(table, constraint string) string
+
+	DropForeignKey(table, name string) string
+}
+
+// LimitOffsetter is implemented by dialects that do not support the
+// "limit n offset m" clause Query uses to page through results.
+type LimitOffsetter interface {
+	// LimitOffset returns the clause, with a leading space, restricting a
+	// query to limit rows after skipping offset rows.  limit is 0 if the
+	// number of rows is not restricted.
+	LimitOffset(limit, offset int) string
+}
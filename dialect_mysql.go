@@ -1,8 +1,11 @@
 package gorp
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 )
 
@@ -135,6 +138,60 @@ func (d MySQLDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// MySQL reports the id of the first row of a multi-row insert.  The ids
+// of the remaining rows are consecutive as long as auto_increment_increment
+// is 1 and innodb_autoinc_lock_mode is not 2 ("interleaved").
+func (d MySQLDialect) InsertBatchAutoIncr(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+	return standardInsertBatchAutoIncr(exec, insertSql, true, targets, params...)
+}
+
+// Returns 65535, the limit of the MySQL client/server protocol
+func (d MySQLDialect) MaxBindVars() int {
+	return 65535
+}
+
+func (d MySQLDialect) MaxInsertRows() int {
+	return 0
+}
+
+// UpsertQuery uses "insert ... on duplicate key update".  MySQL detects
+// conflicts on every unique index of the table, not only the conflict
+// columns.
+func (d MySQLDialect) UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("insert into %s (", d.QuotedTableForQuery(schema, table)))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") values (")
+	s.WriteString(strings.Join(values, ","))
+	s.WriteString(")")
+	s.WriteString(" on duplicate key update ")
+	if len(update) == 0 {
+		// an assignment is required, so make it a no-op
+		s.WriteString(d.QuoteField(conflict[0].ColumnName))
+		s.WriteString("=")
+		s.WriteString(d.QuoteField(conflict[0].ColumnName))
+	}
+	for i, col := range update {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		if col == version {
+			s.WriteString(d.QuoteField(col.ColumnName) + "+1")
+		} else {
+			s.WriteString("values(" + d.QuoteField(col.ColumnName) + ")")
+		}
+	}
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
 func (d MySQLDialect) QuoteField(f string) string {
 	return "`" + f + "`"
 }
@@ -158,3 +215,201 @@ func (d MySQLDialect) IfTableExists(command, schema, table string) string {
 func (d MySQLDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+func (d MySQLDialect) Tables(exec SqlExecutor, schema string) ([]string, error) {
+	rows, err := queryMaps(exec, `select table_name as name from information_schema.tables
+		where table_schema = coalesce(nullif(?, ''), database()) and table_type = 'BASE TABLE'
+		order by table_name`, schema)
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "name"), nil
+}
+
+func (d MySQLDialect) Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error) {
+	rows, err := queryMaps(exec, `select column_name as name, column_type as type, is_nullable as nullable,
+		column_default as dflt
+		from information_schema.columns
+		where table_schema = coalesce(nullif(?, ''), database()) and table_name = ?
+		order by ordinal_position`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(rows))
+	for _, row := range rows {
+		col := ColumnInfo{
+			Name:     mapString(row, "name"),
+			Type:     mapString(row, "type"),
+			Nullable: mapBool(row, "nullable"),
+			Default:  mapNullString(row, "dflt"),
+		}
+		_, col.MaxSize = parseSqlType(col.Type)
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func (d MySQLDialect) Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error) {
+	rows, err := queryMaps(exec, `select index_name as name, min(non_unique) = 0 as is_unique,
+		group_concat(column_name order by seq_in_index separator ',') as columns
+		from information_schema.statistics
+		where table_schema = coalesce(nullif(?, ''), database()) and table_name = ?
+		and index_name <> 'PRIMARY'
+		group by index_name
+		order by index_name`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	return indexInfos(rows), nil
+}
+
+// constraints returns one row per column of the constraints of type
+// constraintType ("PRIMARY KEY" or "UNIQUE") on a table, ordered by
+// constraint.
+func (d MySQLDialect) constraints(exec SqlExecutor, schema, table, constraintType string) ([]map[string]interface{}, error) {
+	return queryMaps(exec, `select tc.constraint_name as name, k.column_name as column_name
+		from information_schema.table_constraints tc
+		join information_schema.key_column_usage k on k.constraint_schema = tc.constraint_schema
+			and k.constraint_name = tc.constraint_name and k.table_name = tc.table_name
+		where tc.table_schema = coalesce(nullif(?, ''), database()) and tc.table_name = ?
+		and tc.constraint_type = ?
+		order by tc.constraint_name, k.ordinal_position`, schema, table, constraintType)
+}
+
+func (d MySQLDialect) PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error) {
+	rows, err := d.constraints(exec, schema, table, "PRIMARY KEY")
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "column_name"), nil
+}
+
+func (d MySQLDialect) UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error) {
+	rows, err := d.constraints(exec, schema, table, "UNIQUE")
+	if err != nil {
+		return nil, err
+	}
+	return constraintRows(rows), nil
+}
+
+func (d MySQLDialect) ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error) {
+	rows, err := queryMaps(exec, `select k.constraint_name as name, k.column_name as column_name,
+		k.referenced_table_schema as ref_schema, k.referenced_table_name as ref_table,
+		k.referenced_column_name as ref_column, r.delete_rule as on_delete, r.update_rule as on_update
+		from information_schema.key_column_usage k
+		join information_schema.referential_constraints r on r.constraint_schema = k.constraint_schema
+			and r.constraint_name = k.constraint_name
+		where k.table_schema = coalesce(nullif(?, ''), database()) and k.table_name = ?
+		order by k.constraint_name, k.ordinal_position`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	return foreignKeyRows(rows), nil
+}
+
+func (d MySQLDialect) AddColumn(table, definition string) string {
+	return fmt.Sprintf("alter table %s add column %s;", table, definition)
+}
+
+func (d MySQLDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s;", table, column)
+}
+
+func (d MySQLDialect) AlterColumn(table, column, sqlType string, notNull bool) string {
+	return fmt.Sprintf("alter table %s modify column %s %s %s;", table, column, sqlType, nullability(!notNull))
+}
+
+func (d MySQLDialect) AddUnique(table, name string, columns []string) string {
+	return fmt.Sprintf("alter table %s add constraint %s unique (%s);", table, d.QuoteField(name), strings.Join(columns, ", "))
+}
+
+func (d MySQLDialect) AddForeignKey(table, constraint string) string {
+	return fmt.Sprintf("alter table %s add %s;", table, constraint)
+}
+
+func (d MySQLDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf("alter table %s drop foreign key %s;", table, d.QuoteField(name))
+}
+
+func (d MySQLDialect) LimitOffset(limit, offset int) string {
+	if limit <= 0 {
+		// MySQL only accepts an offset following a limit
+		return fmt.Sprintf(" limit 18446744073709551615 offset %d", offset)
+	}
+	return fmt.Sprintf(" limit %d offset %d", limit, offset)
+}
+
+// IsRetryable reports deadlocks (1213) and lock wait timeouts (1205).
+func (d MySQLDialect) IsRetryable(err error) bool {
+	_, number, _ := driverError(err)
+	return number == 1213 || number == 1205
+}
+
+// Messages of the MySQL errors naming the constraint, table or column.
+var (
+	mysqlDuplicateEntry = regexp.MustCompile("for key '(?:(?P<table>[^'.]+)\\.)?(?P<constraint>[^']+)'")
+	mysqlForeignKey     = regexp.MustCompile("\\(`[^`]*`\\.`(?P<table>[^`]+)`, CONSTRAINT `(?P<constraint>[^`]+)` FOREIGN KEY \\(`(?P<column>[^`]+)`")
+	mysqlNotNull        = regexp.MustCompile("(?:Column|Field) '(?P<column>[^']+)'")
+	mysqlCheck          = regexp.MustCompile("constraint '(?P<constraint>[^']+)'")
+)
+
+// ClassifyError maps the error number of err onto the errors gorp
+// classifies.  The driver reports no names, so they are read from the
+// message.
+func (d MySQLDialect) ClassifyError(err error) *DbError {
+	_, number, _ := driverError(err)
+	e := &DbError{Err: err}
+	switch number {
+	case 1062, 1586:
+		e.Kind = ErrUniqueViolation
+		return e.messageNames(mysqlDuplicateEntry)
+	case 1216, 1217, 1451, 1452:
+		e.Kind = ErrForeignKeyViolation
+		return e.messageNames(mysqlForeignKey)
+	case 1048, 1364:
+		e.Kind = ErrNotNullViolation
+		return e.messageNames(mysqlNotNull)
+	case 3819:
+		e.Kind = ErrCheckViolation
+		return e.messageNames(mysqlCheck)
+	case 1213:
+		e.Kind = ErrDeadlock
+	case 1205, 3024:
+		e.Kind = ErrTimeout
+	case 1040, 1053, 2002, 2003, 2006, 2013:
+		e.Kind = ErrConnection
+	default:
+		return nil
+	}
+	return e
+}
+
+// TxOptionsStatements sets the isolation level and access mode of the
+// next transaction of the connection.  Unlike sql.TxOptions, this also
+// works with the mymysql driver.
+func (d MySQLDialect) TxOptionsStatements(isolation sql.IsolationLevel, readOnly bool) (setup, reset []string, err error) {
+	var characteristics []string
+	if isolation != sql.LevelDefault {
+		name := isolationName(isolation)
+		if name == "" || isolation == sql.LevelSnapshot {
+			return nil, nil, fmt.Errorf("gorp: MySQL does not support isolation level %v", isolation)
+		}
+		characteristics = append(characteristics, "isolation level "+name)
+	}
+	if readOnly {
+		characteristics = append(characteristics, "read only")
+	}
+	return []string{"set transaction " + strings.Join(characteristics, ", ")}, nil, nil
+}
+
+// ColumnCommentClause gives a column its comment in create table.
+func (d MySQLDialect) ColumnCommentClause(comment string) string {
+	// backslashes are escapes in MySQL string literals
+	return " comment " + quoteLiteral(strings.Replace(comment, `\`, `\\`, -1))
+}
+
+// CommentOnColumn returns "": MySQL cannot comment a column without
+// redefining it.
+func (d MySQLDialect) CommentOnColumn(table, column, comment string) string {
+	return ""
+}
@@ -1,8 +1,10 @@
 package gorp
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 )
 
@@ -110,6 +112,57 @@ func (d OracleDialect) InsertQueryToTarget(exec SqlExecutor, insertSql, idSql st
 	return nil
 }
 
+// UpsertQuery uses a "merge" statement.
+func (d OracleDialect) UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("merge into %s tgt using (select ", d.QuotedTableForQuery(schema, table)))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(values[i] + " as " + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(" from dual) src on (")
+	for i, col := range conflict {
+		if i > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "=src." + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(")")
+	if len(update) > 0 {
+		s.WriteString(" when matched then update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "=")
+			if col == version {
+				s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "+1")
+			} else {
+				s.WriteString("src." + d.QuoteField(col.ColumnName))
+			}
+		}
+	}
+	s.WriteString(" when not matched then insert (")
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") values (")
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString("src." + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(")")
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
 func (d OracleDialect) QuoteField(f string) string {
 	return `"` + strings.ToUpper(f) + `"`
 }
@@ -133,3 +186,204 @@ func (d OracleDialect) IfTableExists(command, schema, table string) string {
 func (d OracleDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+func (d OracleDialect) AddColumn(table, definition string) string {
+	return fmt.Sprintf("alter table %s add (%s)", table, definition)
+}
+
+func (d OracleDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s", table, column)
+}
+
+func (d OracleDialect) AlterColumn(table, column, sqlType string, notNull bool) string {
+	return fmt.Sprintf("alter table %s modify (%s %s %s)", table, column, sqlType, nullability(!notNull))
+}
+
+func (d OracleDialect) AddUnique(table, name string, columns []string) string {
+	return fmt.Sprintf("alter table %s add constraint %s unique (%s)", table, d.QuoteField(name), strings.Join(columns, ", "))
+}
+
+func (d OracleDialect) AddForeignKey(table, constraint string) string {
+	return fmt.Sprintf("alter table %s add %s", table, constraint)
+}
+
+func (d OracleDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf("alter table %s drop constraint %s", table, d.QuoteField(name))
+}
+
+// oracleOwner selects the given schema, or the current schema if it is
+// empty.  Oracle treats the empty string as NULL.
+const oracleOwner = "nvl(:1, sys_context('USERENV', 'CURRENT_SCHEMA'))"
+
+func (d OracleDialect) Tables(exec SqlExecutor, schema string) ([]string, error) {
+	rows, err := queryMaps(exec, `select table_name as name from all_tables
+		where owner = `+oracleOwner+`
+		order by table_name`, strings.ToUpper(schema))
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "name"), nil
+}
+
+func (d OracleDialect) Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error) {
+	rows, err := queryMaps(exec, `select column_name as name,
+		case
+			when data_type in ('VARCHAR2', 'NVARCHAR2', 'CHAR', 'NCHAR') then
+				lower(data_type) || '(' || char_length || ')'
+			when data_type = 'NUMBER' and data_precision is not null then
+				'number(' || data_precision || ',' || nvl(data_scale, 0) || ')'
+			else lower(data_type) end as type,
+		nullable, data_default as dflt
+		from all_tab_columns
+		where owner = `+oracleOwner+` and table_name = :2
+		order by column_id`, strings.ToUpper(schema), strings.ToUpper(table))
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(rows))
+	for _, row := range rows {
+		col := ColumnInfo{
+			Name:     mapString(row, "name"),
+			Type:     mapString(row, "type"),
+			Nullable: mapBool(row, "nullable"),
+			Default:  mapNullString(row, "dflt"),
+		}
+		// data_default keeps the whitespace following the expression
+		col.Default.String = strings.TrimSpace(col.Default.String)
+		_, col.MaxSize = parseSqlType(col.Type)
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// constraints returns one row per column of the constraints of type
+// constraintType ("P" or "U") on a table, ordered by constraint.
+func (d OracleDialect) constraints(exec SqlExecutor, schema, table, constraintType string) ([]map[string]interface{}, error) {
+	return queryMaps(exec, `select c.constraint_name as name, cc.column_name as column_name
+		from all_constraints c
+		join all_cons_columns cc on cc.owner = c.owner and cc.constraint_name = c.constraint_name
+		where c.owner = `+oracleOwner+` and c.table_name = :2 and c.constraint_type = :3
+		order by c.constraint_name, cc.position`, strings.ToUpper(schema), strings.ToUpper(table), constraintType)
+}
+
+func (d OracleDialect) PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error) {
+	rows, err := d.constraints(exec, schema, table, "P")
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "column_name"), nil
+}
+
+func (d OracleDialect) Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error) {
+	rows, err := queryMaps(exec, `select i.index_name as name,
+		case when i.uniqueness = 'UNIQUE' then 1 else 0 end as is_unique,
+		c.column_name as column_name
+		from all_indexes i
+		join all_ind_columns c on c.index_owner = i.owner and c.index_name = i.index_name
+		where i.table_owner = `+oracleOwner+` and i.table_name = :2
+		and not exists (select 1 from all_constraints k where k.owner = i.table_owner
+			and k.table_name = i.table_name and k.constraint_type = 'P' and k.index_name = i.index_name)
+		order by i.index_name, c.column_position`, strings.ToUpper(schema), strings.ToUpper(table))
+	if err != nil {
+		return nil, err
+	}
+	return indexRows(rows), nil
+}
+
+func (d OracleDialect) UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error) {
+	rows, err := d.constraints(exec, schema, table, "U")
+	if err != nil {
+		return nil, err
+	}
+	return constraintRows(rows), nil
+}
+
+// ForeignKeys reports OnUpdate as "NO ACTION", the only update rule
+// Oracle supports.
+func (d OracleDialect) ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error) {
+	rows, err := queryMaps(exec, `select c.constraint_name as name, cc.column_name as column_name,
+		rc.owner as ref_schema, rc.table_name as ref_table, rc.column_name as ref_column,
+		c.delete_rule as on_delete, 'NO ACTION' as on_update
+		from all_constraints c
+		join all_cons_columns cc on cc.owner = c.owner and cc.constraint_name = c.constraint_name
+		join all_cons_columns rc on rc.owner = c.r_owner and rc.constraint_name = c.r_constraint_name
+			and rc.position = cc.position
+		where c.owner = `+oracleOwner+` and c.table_name = :2 and c.constraint_type = 'R'
+		order by c.constraint_name, cc.position`, strings.ToUpper(schema), strings.ToUpper(table))
+	if err != nil {
+		return nil, err
+	}
+	return foreignKeyRows(rows), nil
+}
+
+// LimitOffset requires Oracle 12c or newer.
+func (d OracleDialect) LimitOffset(limit, offset int) string {
+	s := fmt.Sprintf(" offset %d rows", offset)
+	if limit > 0 {
+		s += fmt.Sprintf(" fetch next %d rows only", limit)
+	}
+	return s
+}
+
+// IsRetryable reports serialization failures (ORA-08177) and deadlocks
+// (ORA-00060).  Oracle drivers do not agree on how they expose error
+// codes, so they are read from the message.
+func (d OracleDialect) IsRetryable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "ORA-08177") || strings.Contains(msg, "ORA-00060")
+}
+
+// Patterns of the ORA- code of an Oracle error, and of the names in its
+// message: "unique constraint (SCHEMA.NAME) violated" or
+// "cannot insert NULL into ("SCHEMA"."TABLE"."COLUMN")".
+var (
+	oracleCode       = regexp.MustCompile(`ORA-(\d{5})`)
+	oracleConstraint = regexp.MustCompile(`constraint \((?:[^.)]+\.)?(?P<constraint>[^)]+)\)`)
+	oracleNull       = regexp.MustCompile(`into \((?:"[^"]+"\.)?"(?P<table>[^"]+)"\."(?P<column>[^"]+)"\)`)
+)
+
+// ClassifyError maps the ORA- code in the message of err onto the errors
+// gorp classifies.  See IsRetryable.
+func (d OracleDialect) ClassifyError(err error) *DbError {
+	match := oracleCode.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil
+	}
+	e := &DbError{Err: err}
+	switch match[1] {
+	case "00001":
+		e.Kind = ErrUniqueViolation
+		return e.messageNames(oracleConstraint)
+	case "02291", "02292":
+		e.Kind = ErrForeignKeyViolation
+		return e.messageNames(oracleConstraint)
+	case "01400", "01407":
+		e.Kind = ErrNotNullViolation
+		return e.messageNames(oracleNull)
+	case "02290":
+		e.Kind = ErrCheckViolation
+		return e.messageNames(oracleConstraint)
+	case "00060":
+		e.Kind = ErrDeadlock
+	case "08177":
+		e.Kind = ErrSerialization
+	case "00054", "01013", "30006":
+		e.Kind = ErrTimeout
+	case "03113", "03114", "03135", "12170", "12541":
+		e.Kind = ErrConnection
+	default:
+		return nil
+	}
+	return e
+}
+
+// ColumnCommentClause returns "": Oracle comments columns with
+// "comment on column".
+func (d OracleDialect) ColumnCommentClause(comment string) string {
+	return ""
+}
+
+// CommentOnColumn gives an existing column its comment.
+func (d OracleDialect) CommentOnColumn(table, column, comment string) string {
+	return fmt.Sprintf("comment on column %s.%s is %s", table, column, quoteLiteral(comment))
+}
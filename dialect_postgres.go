@@ -1,6 +1,7 @@
 package gorp
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"strings"
@@ -100,7 +101,10 @@ func (d PostgresDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql stri
 	defer rows.Close()
 
 	if !rows.Next() {
-		return fmt.Errorf("No serial value returned for insert: %s Encountered error: %s", insertSql, rows.Err())
+		if err := rows.Err(); err != nil {
+			return classifyExecError(exec, err)
+		}
+		return fmt.Errorf("No serial value returned for insert: %s", insertSql)
 	}
 	if err := rows.Scan(target); err != nil {
 		return err
@@ -108,7 +112,85 @@ func (d PostgresDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql stri
 	if rows.Next() {
 		return fmt.Errorf("more than two serial value returned for insert: %s", insertSql)
 	}
-	return rows.Err()
+	return classifyExecError(exec, rows.Err())
+}
+
+// InsertBatchAutoIncr scans the keys returned by the "returning" clause of
+// a multi-row insert, assigning them to the rows in the order they were
+// listed.  PostgreSQL returns them in that order in practice, but does
+// not document it.
+func (d PostgresDialect) InsertBatchAutoIncr(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+	rows, err := exec.query(insertSql, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for _, target := range targets {
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return classifyExecError(exec, err)
+			}
+			return fmt.Errorf("Not enough serial values returned for insert: %s", insertSql)
+		}
+		if err := rows.Scan(target); err != nil {
+			return err
+		}
+	}
+	if rows.Next() {
+		return fmt.Errorf("more serial values returned than rows inserted for insert: %s", insertSql)
+	}
+	return classifyExecError(exec, rows.Err())
+}
+
+// Returns 65535, the limit of the Postgres wire protocol
+func (d PostgresDialect) MaxBindVars() int {
+	return 65535
+}
+
+func (d PostgresDialect) MaxInsertRows() int {
+	return 0
+}
+
+// UpsertQuery uses "insert ... on conflict (...) do update".
+func (d PostgresDialect) UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("insert into %s (", d.QuotedTableForQuery(schema, table)))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") values (")
+	s.WriteString(strings.Join(values, ","))
+	s.WriteString(")")
+	s.WriteString(" on conflict (")
+	for i, col := range conflict {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	if len(update) == 0 {
+		s.WriteString(") do nothing")
+	} else {
+		s.WriteString(") do update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(d.QuoteField(col.ColumnName))
+			s.WriteString("=")
+			if col == version {
+				s.WriteString(d.QuoteField(table) + "." + d.QuoteField(col.ColumnName) + "+1")
+			} else {
+				s.WriteString("excluded." + d.QuoteField(col.ColumnName))
+			}
+		}
+	}
+	s.WriteString(d.QuerySuffix())
+	return s.String()
 }
 
 func (d PostgresDialect) QuoteField(f string) string {
@@ -134,3 +216,189 @@ func (d PostgresDialect) IfTableExists(command, schema, table string) string {
 func (d PostgresDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+func (d PostgresDialect) Tables(exec SqlExecutor, schema string) ([]string, error) {
+	rows, err := queryMaps(exec, `select table_name as name from information_schema.tables
+		where table_schema = coalesce(nullif($1, ''), current_schema()) and table_type = 'BASE TABLE'
+		order by table_name`, strings.ToLower(schema))
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "name"), nil
+}
+
+func (d PostgresDialect) Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error) {
+	rows, err := queryMaps(exec, `select a.attname as name, format_type(a.atttypid, a.atttypmod) as type,
+		not a.attnotnull as nullable, pg_get_expr(d.adbin, d.adrelid) as dflt
+		from pg_attribute a
+		join pg_class c on c.oid = a.attrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+		where c.relname = $1 and n.nspname = coalesce(nullif($2, ''), current_schema())
+		and c.relkind = 'r' and a.attnum > 0 and not a.attisdropped
+		order by a.attnum`, strings.ToLower(table), strings.ToLower(schema))
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(rows))
+	for _, row := range rows {
+		col := ColumnInfo{
+			Name:     mapString(row, "name"),
+			Type:     mapString(row, "type"),
+			Nullable: mapBool(row, "nullable"),
+			Default:  mapNullString(row, "dflt"),
+		}
+		_, col.MaxSize = parseSqlType(col.Type)
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func (d PostgresDialect) Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error) {
+	rows, err := queryMaps(exec, `select i.relname as name, ix.indisunique as is_unique,
+		array_to_string(array(
+			select a.attname from unnest(ix.indkey::int2[]) with ordinality as k(attnum, ord)
+			join pg_attribute a on a.attrelid = t.oid and a.attnum = k.attnum
+			order by k.ord), ',') as columns
+		from pg_index ix
+		join pg_class t on t.oid = ix.indrelid
+		join pg_class i on i.oid = ix.indexrelid
+		join pg_namespace n on n.oid = t.relnamespace
+		where t.relname = $1 and n.nspname = coalesce(nullif($2, ''), current_schema())
+		and not ix.indisprimary
+		order by i.relname`, strings.ToLower(table), strings.ToLower(schema))
+	if err != nil {
+		return nil, err
+	}
+	return indexInfos(rows), nil
+}
+
+// constraints returns one row per column of the constraints of type
+// contype ('p', 'u' or 'f') on a table, ordered by constraint.
+func (d PostgresDialect) constraints(exec SqlExecutor, schema, table, contype string) ([]map[string]interface{}, error) {
+	return queryMaps(exec, `select con.conname as name, a.attname as column_name,
+		coalesce(rn.nspname, '') as ref_schema, coalesce(rt.relname, '') as ref_table,
+		coalesce(ra.attname, '') as ref_column,
+		case con.confdeltype when 'r' then 'RESTRICT' when 'c' then 'CASCADE'
+			when 'n' then 'SET NULL' when 'd' then 'SET DEFAULT' else 'NO ACTION' end as on_delete,
+		case con.confupdtype when 'r' then 'RESTRICT' when 'c' then 'CASCADE'
+			when 'n' then 'SET NULL' when 'd' then 'SET DEFAULT' else 'NO ACTION' end as on_update
+		from pg_constraint con
+		join pg_class t on t.oid = con.conrelid
+		join pg_namespace n on n.oid = t.relnamespace
+		cross join lateral unnest(con.conkey) with ordinality as k(attnum, ord)
+		join pg_attribute a on a.attrelid = con.conrelid and a.attnum = k.attnum
+		left join pg_attribute ra on ra.attrelid = con.confrelid and ra.attnum = con.confkey[k.ord]
+		left join pg_class rt on rt.oid = con.confrelid
+		left join pg_namespace rn on rn.oid = rt.relnamespace
+		where t.relname = $1 and n.nspname = coalesce(nullif($2, ''), current_schema())
+		and con.contype = $3
+		order by con.conname, k.ord`, strings.ToLower(table), strings.ToLower(schema), contype)
+}
+
+func (d PostgresDialect) PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error) {
+	rows, err := d.constraints(exec, schema, table, "p")
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "column_name"), nil
+}
+
+func (d PostgresDialect) UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error) {
+	rows, err := d.constraints(exec, schema, table, "u")
+	if err != nil {
+		return nil, err
+	}
+	return constraintRows(rows), nil
+}
+
+func (d PostgresDialect) ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error) {
+	rows, err := d.constraints(exec, schema, table, "f")
+	if err != nil {
+		return nil, err
+	}
+	return foreignKeyRows(rows), nil
+}
+
+func (d PostgresDialect) AddColumn(table, definition string) string {
+	return fmt.Sprintf("alter table %s add column %s;", table, definition)
+}
+
+func (d PostgresDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s;", table, column)
+}
+
+func (d PostgresDialect) AlterColumn(table, column, sqlType string, notNull bool) string {
+	nullability := "drop not null"
+	if notNull {
+		nullability = "set not null"
+	}
+	return fmt.Sprintf("alter table %s alter column %s type %s, alter column %s %s;", table, column, sqlType, column, nullability)
+}
+
+func (d PostgresDialect) AddUnique(table, name string, columns []string) string {
+	return fmt.Sprintf("alter table %s add constraint %s unique (%s);", table, d.QuoteField(name), strings.Join(columns, ", "))
+}
+
+func (d PostgresDialect) AddForeignKey(table, constraint string) string {
+	return fmt.Sprintf("alter table %s add %s;", table, constraint)
+}
+
+func (d PostgresDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf("alter table %s drop constraint %s;", table, d.QuoteField(name))
+}
+
+// IsRetryable reports serialization failures (40001) and deadlocks
+// (40P01).
+func (d PostgresDialect) IsRetryable(err error) bool {
+	state, _, _ := driverError(err)
+	return state == "40001" || state == "40P01"
+}
+
+// ClassifyError maps the SQLSTATE of err onto the errors gorp classifies,
+// with the constraint, table and column names reported by lib/pq and pgx.
+func (d PostgresDialect) ClassifyError(err error) *DbError {
+	state, _, _ := driverError(err)
+	var kind error
+	switch state {
+	case "23505":
+		kind = ErrUniqueViolation
+	case "23503":
+		kind = ErrForeignKeyViolation
+	case "23502":
+		kind = ErrNotNullViolation
+	case "23514":
+		kind = ErrCheckViolation
+	case "40P01":
+		kind = ErrDeadlock
+	case "40001":
+		kind = ErrSerialization
+	case "57014", "55P03":
+		kind = ErrTimeout
+	case "57P01", "57P02", "57P03":
+		kind = ErrConnection
+	default:
+		if !strings.HasPrefix(state, "08") {
+			return nil
+		}
+		kind = ErrConnection
+	}
+	return &DbError{
+		Kind:       kind,
+		Constraint: driverString(err, "Constraint", "ConstraintName"),
+		Table:      driverString(err, "Table", "TableName"),
+		Column:     driverString(err, "Column", "ColumnName"),
+		Err:        err,
+	}
+}
+
+// ColumnCommentClause returns "": PostgreSQL comments columns with
+// "comment on column".
+func (d PostgresDialect) ColumnCommentClause(comment string) string {
+	return ""
+}
+
+// CommentOnColumn gives an existing column its comment.
+func (d PostgresDialect) CommentOnColumn(table, column, comment string) string {
+	return fmt.Sprintf("comment on column %s.%s is %s;", table, column, quoteLiteral(comment))
+}
@@ -1,8 +1,12 @@
 package gorp
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 )
 
 type SqliteDialect struct {
@@ -86,6 +90,63 @@ func (d SqliteDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// SQLite reports the rowid of the last row of a multi-row insert, and
+// assigns the rows consecutive rowids.
+func (d SqliteDialect) InsertBatchAutoIncr(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+	return standardInsertBatchAutoIncr(exec, insertSql, false, targets, params...)
+}
+
+// Returns 999, the SQLITE_MAX_VARIABLE_NUMBER default before SQLite 3.32
+func (d SqliteDialect) MaxBindVars() int {
+	return 999
+}
+
+func (d SqliteDialect) MaxInsertRows() int {
+	return 0
+}
+
+// UpsertQuery uses "insert ... on conflict (...) do update", which
+// requires SQLite 3.24 or newer.
+func (d SqliteDialect) UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("insert into %s (", d.QuotedTableForQuery(schema, table)))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") values (")
+	s.WriteString(strings.Join(values, ","))
+	s.WriteString(")")
+	s.WriteString(" on conflict (")
+	for i, col := range conflict {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	if len(update) == 0 {
+		s.WriteString(") do nothing")
+	} else {
+		s.WriteString(") do update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(d.QuoteField(col.ColumnName))
+			s.WriteString("=")
+			if col == version {
+				s.WriteString(d.QuoteField(table) + "." + d.QuoteField(col.ColumnName) + "+1")
+			} else {
+				s.WriteString("excluded." + d.QuoteField(col.ColumnName))
+			}
+		}
+	}
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
 func (d SqliteDialect) QuoteField(f string) string {
 	return `"` + f + `"`
 }
@@ -106,3 +167,235 @@ func (d SqliteDialect) IfTableExists(command, schema, table string) string {
 func (d SqliteDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+// pragma returns a pragma statement taking a table or index name, run
+// against schema if it is not empty.
+func (d SqliteDialect) pragma(name, schema, arg string) string {
+	if schema != "" {
+		name = d.QuoteField(schema) + "." + name
+	}
+	return fmt.Sprintf("pragma %s(%s)", name, d.QuoteField(arg))
+}
+
+func (d SqliteDialect) Tables(exec SqlExecutor, schema string) ([]string, error) {
+	master := "sqlite_master"
+	if schema != "" {
+		master = d.QuoteField(schema) + "." + master
+	}
+	rows, err := queryMaps(exec, "select name from "+master+
+		" where type = 'table' and name not like 'sqlite_%' order by name")
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "name"), nil
+}
+
+func (d SqliteDialect) Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error) {
+	rows, err := queryMaps(exec, d.pragma("table_info", schema, table))
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(rows))
+	for _, row := range rows {
+		col := ColumnInfo{
+			Name:     mapString(row, "name"),
+			Type:     mapString(row, "type"),
+			Nullable: !mapBool(row, "notnull"),
+			Default:  mapNullString(row, "dflt_value"),
+		}
+		_, col.MaxSize = parseSqlType(col.Type)
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func (d SqliteDialect) PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error) {
+	rows, err := queryMaps(exec, d.pragma("table_info", schema, table))
+	if err != nil {
+		return nil, err
+	}
+	// pk holds the 1-based position of the column in the primary key
+	keys := make([]string, len(rows))
+	n := 0
+	for _, row := range rows {
+		if pk, _ := row["pk"].(int64); pk > 0 && int(pk) <= len(keys) {
+			keys[pk-1] = mapString(row, "name")
+			n++
+		}
+	}
+	return keys[:n], nil
+}
+
+// indexList returns the indexes of a table created the given way: "c"
+// for create index, "u" for unique constraints and "pk" for the primary
+// key.
+func (d SqliteDialect) indexList(exec SqlExecutor, schema, table string, origins ...string) ([]IndexInfo, error) {
+	rows, err := queryMaps(exec, d.pragma("index_list", schema, table))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []IndexInfo
+	for _, row := range rows {
+		origin := mapString(row, "origin")
+		match := false
+		for _, o := range origins {
+			match = match || o == origin
+		}
+		if !match {
+			continue
+		}
+		idx := IndexInfo{Name: mapString(row, "name"), Unique: mapBool(row, "unique")}
+		cols, err := queryMaps(exec, d.pragma("index_info", schema, idx.Name))
+		if err != nil {
+			return nil, err
+		}
+		idx.Columns = stringColumn(cols, "name")
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+func (d SqliteDialect) Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error) {
+	return d.indexList(exec, schema, table, "c", "u")
+}
+
+func (d SqliteDialect) UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error) {
+	indexes, err := d.indexList(exec, schema, table, "u")
+	if err != nil {
+		return nil, err
+	}
+	constraints := make([]ConstraintInfo, len(indexes))
+	for i, idx := range indexes {
+		constraints[i] = ConstraintInfo{Name: idx.Name, Columns: idx.Columns}
+	}
+	return constraints, nil
+}
+
+func (d SqliteDialect) ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error) {
+	rows, err := queryMaps(exec, d.pragma("foreign_key_list", schema, table))
+	if err != nil {
+		return nil, err
+	}
+	// foreign_key_list has one row per column, numbered by id and seq
+	for _, row := range rows {
+		row["name"] = row["id"]
+		row["column_name"] = row["from"]
+		row["ref_table"] = row["table"]
+		row["ref_column"] = row["to"]
+		row["ref_schema"] = schema
+	}
+	fks := foreignKeyRows(rows)
+	for i := range fks {
+		fks[i].Name = ""
+	}
+	return fks, nil
+}
+
+func (d SqliteDialect) AddColumn(table, definition string) string {
+	return fmt.Sprintf("alter table %s add column %s;", table, definition)
+}
+
+// DropColumn requires SQLite 3.35 or newer.
+func (d SqliteDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s;", table, column)
+}
+
+// SQLite cannot alter columns, the table has to be rebuilt instead.
+func (d SqliteDialect) AlterColumn(table, column, sqlType string, notNull bool) string {
+	return ""
+}
+
+// SQLite cannot add constraints to existing tables, so a unique index is
+// created instead.
+func (d SqliteDialect) AddUnique(table, name string, columns []string) string {
+	return fmt.Sprintf("create unique index %s on %s (%s);", d.QuoteField(name), table, strings.Join(columns, ", "))
+}
+
+func (d SqliteDialect) LimitOffset(limit, offset int) string {
+	if limit <= 0 {
+		// SQLite only accepts an offset following a limit
+		limit = -1
+	}
+	return fmt.Sprintf(" limit %d offset %d", limit, offset)
+}
+
+// IsRetryable reports SQLITE_BUSY errors, returned when another
+// connection holds a conflicting lock on the database.
+func (d SqliteDialect) IsRetryable(err error) bool {
+	_, number, _ := driverError(err)
+	return number == 5
+}
+
+// Messages of the SQLite constraint errors, which name the columns as
+// table.column, or the constraint.  Only the first column is kept.
+var (
+	sqliteUnique  = regexp.MustCompile(`^UNIQUE constraint failed: (?P<table>[^.]+)\.(?P<column>[^,]+)`)
+	sqliteNotNull = regexp.MustCompile(`^NOT NULL constraint failed: (?P<table>[^.]+)\.(?P<column>[^,]+)`)
+	sqliteCheck   = regexp.MustCompile(`^CHECK constraint failed: (?P<constraint>.+)$`)
+)
+
+// ClassifyError maps the result code of err onto the errors gorp
+// classifies.  Constraint errors are told apart by their extended result
+// code, or their message if the driver has none.  SQLITE_BUSY errors,
+// returned once the busy timeout expired, are timeouts.
+func (d SqliteDialect) ClassifyError(err error) *DbError {
+	_, number, ok := driverError(err)
+	if !ok {
+		return nil
+	}
+	e := &DbError{Err: err}
+	switch f := driverField(err, "ExtendedCode"); {
+	case f.CanInt() && f.Int() == 517: // SQLITE_BUSY_SNAPSHOT
+		e.Kind = ErrSerialization
+		return e
+	case number == 5:
+		e.Kind = ErrTimeout
+		return e
+	case number == 14: // SQLITE_CANTOPEN
+		e.Kind = ErrConnection
+		return e
+	case number != 19: // SQLITE_CONSTRAINT
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "UNIQUE"):
+		e.Kind = ErrUniqueViolation
+		e.messageNames(sqliteUnique)
+	case strings.HasPrefix(msg, "NOT NULL"):
+		e.Kind = ErrNotNullViolation
+		e.messageNames(sqliteNotNull)
+	case strings.HasPrefix(msg, "CHECK"):
+		e.Kind = ErrCheckViolation
+		e.messageNames(sqliteCheck)
+	case strings.HasPrefix(msg, "FOREIGN KEY"):
+		e.Kind = ErrForeignKeyViolation
+	default:
+		return nil
+	}
+	return e
+}
+
+// TxOptionsStatements makes the connection refuse writes for read-only
+// transactions.  SQLite transactions are serializable, which satisfies
+// every isolation level but linearizable.
+func (d SqliteDialect) TxOptionsStatements(isolation sql.IsolationLevel, readOnly bool) (setup, reset []string, err error) {
+	if isolation == sql.LevelLinearizable {
+		return nil, nil, fmt.Errorf("gorp: SQLite does not support isolation level %v", isolation)
+	}
+	if readOnly {
+		return []string{"pragma query_only = 1;"}, []string{"pragma query_only = 0;"}, nil
+	}
+	return nil, nil, nil
+}
+
+// BeginStatement begins the writable transactions given an isolation
+// level with "begin immediate", which takes the write lock at once.  A
+// concurrent writer then waits for it when it begins, up to the busy
+// timeout, instead of failing with SQLITE_BUSY at its first write.
+func (d SqliteDialect) BeginStatement(isolation sql.IsolationLevel, readOnly bool) string {
+	if readOnly || isolation == sql.LevelDefault {
+		return ""
+	}
+	return "begin immediate;"
+}
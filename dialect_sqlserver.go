@@ -1,8 +1,11 @@
 package gorp
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 )
 
@@ -101,6 +104,68 @@ func (d SqlServerDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, par
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// Returns 2100, the maximum number of parameters of a SQL Server statement
+func (d SqlServerDialect) MaxBindVars() int {
+	return 2100
+}
+
+// Returns 1000, the maximum number of rows in a table value constructor
+func (d SqlServerDialect) MaxInsertRows() int {
+	return 1000
+}
+
+// UpsertQuery uses a "merge" statement, holding a range lock on the
+// target so that concurrent upserts of the same key do not race.
+func (d SqlServerDialect) UpsertQuery(schema, table string, cols []*ColumnMap, values []string, conflict, update []*ColumnMap, version *ColumnMap) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("merge into %s with (holdlock) as tgt using (select ", d.QuotedTableForQuery(schema, table)))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(values[i] + " as " + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") as src on (")
+	for i, col := range conflict {
+		if i > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "=src." + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(")")
+	if len(update) > 0 {
+		s.WriteString(" when matched then update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "=")
+			if col == version {
+				s.WriteString("tgt." + d.QuoteField(col.ColumnName) + "+1")
+			} else {
+				s.WriteString("src." + d.QuoteField(col.ColumnName))
+			}
+		}
+	}
+	s.WriteString(" when not matched then insert (")
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(") values (")
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString("src." + d.QuoteField(col.ColumnName))
+	}
+	s.WriteString(")")
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
 func (d SqlServerDialect) QuoteField(f string) string {
 	return "[" + strings.Replace(f, "]", "]]", -1) + "]"
 }
@@ -139,3 +204,207 @@ func (d SqlServerDialect) IfTableNotExists(command, schema, table string) string
 
 func (d SqlServerDialect) CreateIndexSuffix() string { return "" }
 func (d SqlServerDialect) DropIndexSuffix() string   { return "" }
+
+func (d SqlServerDialect) AddColumn(table, definition string) string {
+	return fmt.Sprintf("alter table %s add %s;", table, definition)
+}
+
+func (d SqlServerDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s;", table, column)
+}
+
+func (d SqlServerDialect) AlterColumn(table, column, sqlType string, notNull bool) string {
+	return fmt.Sprintf("alter table %s alter column %s %s %s;", table, column, sqlType, nullability(!notNull))
+}
+
+func (d SqlServerDialect) AddUnique(table, name string, columns []string) string {
+	return fmt.Sprintf("alter table %s add constraint %s unique (%s);", table, d.QuoteField(name), strings.Join(columns, ", "))
+}
+
+func (d SqlServerDialect) AddForeignKey(table, constraint string) string {
+	return fmt.Sprintf("alter table %s add %s;", table, constraint)
+}
+
+func (d SqlServerDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf("alter table %s drop constraint %s;", table, d.QuoteField(name))
+}
+
+func (d SqlServerDialect) Tables(exec SqlExecutor, schema string) ([]string, error) {
+	rows, err := queryMaps(exec, `select table_name as name from information_schema.tables
+		where table_schema = coalesce(nullif(?, ''), schema_name()) and table_type = 'BASE TABLE'
+		order by table_name`, schema)
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "name"), nil
+}
+
+func (d SqlServerDialect) Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error) {
+	rows, err := queryMaps(exec, `select column_name as name,
+		data_type + case
+			when character_maximum_length = -1 then '(max)'
+			when character_maximum_length > 0 then '(' + cast(character_maximum_length as varchar(10)) + ')'
+			when data_type in ('decimal', 'numeric') then
+				'(' + cast(numeric_precision as varchar(10)) + ',' + cast(numeric_scale as varchar(10)) + ')'
+			when data_type = 'float' then '(' + cast(numeric_precision as varchar(10)) + ')'
+			else '' end as type,
+		is_nullable as nullable, column_default as dflt
+		from information_schema.columns
+		where table_schema = coalesce(nullif(?, ''), schema_name()) and table_name = ?
+		order by ordinal_position`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, 0, len(rows))
+	for _, row := range rows {
+		col := ColumnInfo{
+			Name:     mapString(row, "name"),
+			Type:     mapString(row, "type"),
+			Nullable: mapBool(row, "nullable"),
+			Default:  mapNullString(row, "dflt"),
+		}
+		_, col.MaxSize = parseSqlType(col.Type)
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// constraints returns one row per column of the constraints of type
+// constraintType ("PRIMARY KEY" or "UNIQUE") on a table, ordered by
+// constraint.
+func (d SqlServerDialect) constraints(exec SqlExecutor, schema, table, constraintType string) ([]map[string]interface{}, error) {
+	return queryMaps(exec, `select tc.constraint_name as name, k.column_name as column_name
+		from information_schema.table_constraints tc
+		join information_schema.key_column_usage k on k.constraint_schema = tc.constraint_schema
+			and k.constraint_name = tc.constraint_name
+		where tc.table_schema = coalesce(nullif(?, ''), schema_name()) and tc.table_name = ?
+		and tc.constraint_type = ?
+		order by tc.constraint_name, k.ordinal_position`, schema, table, constraintType)
+}
+
+func (d SqlServerDialect) PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error) {
+	rows, err := d.constraints(exec, schema, table, "PRIMARY KEY")
+	if err != nil {
+		return nil, err
+	}
+	return stringColumn(rows, "column_name"), nil
+}
+
+func (d SqlServerDialect) Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error) {
+	rows, err := queryMaps(exec, `select i.name as name, i.is_unique as is_unique, c.name as column_name
+		from sys.indexes i
+		join sys.index_columns ic on ic.object_id = i.object_id and ic.index_id = i.index_id
+		join sys.columns c on c.object_id = ic.object_id and c.column_id = ic.column_id
+		where i.object_id = object_id(?) and i.is_primary_key = 0 and ic.is_included_column = 0
+		order by i.name, ic.key_ordinal`, d.QuotedTableForQuery(schema, table))
+	if err != nil {
+		return nil, err
+	}
+	return indexRows(rows), nil
+}
+
+func (d SqlServerDialect) UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error) {
+	rows, err := d.constraints(exec, schema, table, "UNIQUE")
+	if err != nil {
+		return nil, err
+	}
+	return constraintRows(rows), nil
+}
+
+func (d SqlServerDialect) ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error) {
+	rows, err := queryMaps(exec, `select fk.name as name,
+		col_name(fkc.parent_object_id, fkc.parent_column_id) as column_name,
+		object_schema_name(fk.referenced_object_id) as ref_schema,
+		object_name(fk.referenced_object_id) as ref_table,
+		col_name(fkc.referenced_object_id, fkc.referenced_column_id) as ref_column,
+		fk.delete_referential_action_desc as on_delete,
+		fk.update_referential_action_desc as on_update
+		from sys.foreign_keys fk
+		join sys.foreign_key_columns fkc on fkc.constraint_object_id = fk.object_id
+		where fk.parent_object_id = object_id(?)
+		order by fk.name, fkc.constraint_column_id`, d.QuotedTableForQuery(schema, table))
+	if err != nil {
+		return nil, err
+	}
+	return foreignKeyRows(rows), nil
+}
+
+// LimitOffset requires SQL Server 2012 or newer, and the query to have
+// an order by clause.
+func (d SqlServerDialect) LimitOffset(limit, offset int) string {
+	s := fmt.Sprintf(" offset %d rows", offset)
+	if limit > 0 {
+		s += fmt.Sprintf(" fetch next %d rows only", limit)
+	}
+	return s
+}
+
+// IsRetryable reports transactions chosen as deadlock victims (1205).
+func (d SqlServerDialect) IsRetryable(err error) bool {
+	_, number, _ := driverError(err)
+	return number == 1205
+}
+
+// Messages of the SQL Server errors naming the constraint, table or
+// column.
+var (
+	sqlServerDuplicateKey = regexp.MustCompile(`constraint '(?P<constraint>[^']+)'. Cannot insert duplicate key in object '(?P<table>[^']+)'`)
+	sqlServerDuplicateRow = regexp.MustCompile(`in object '(?P<table>[^']+)' with unique index '(?P<constraint>[^']+)'`)
+	sqlServerConflict     = regexp.MustCompile(`constraint "(?P<constraint>[^"]+)"(?:.*table "(?P<table>[^"]+)"(?:, column '(?P<column>[^']+)')?)?`)
+	sqlServerNull         = regexp.MustCompile(`column '(?P<column>[^']+)', table '(?P<table>[^']+)'`)
+)
+
+// ClassifyError maps the error number of err onto the errors gorp
+// classifies.  The driver reports no names, so they are read from the
+// message.
+func (d SqlServerDialect) ClassifyError(err error) *DbError {
+	_, number, _ := driverError(err)
+	e := &DbError{Err: err}
+	switch number {
+	case 2627:
+		e.Kind = ErrUniqueViolation
+		return e.messageNames(sqlServerDuplicateKey)
+	case 2601:
+		e.Kind = ErrUniqueViolation
+		return e.messageNames(sqlServerDuplicateRow)
+	case 547:
+		// Both foreign key and check constraints fail with 547.
+		e.Kind = ErrForeignKeyViolation
+		if strings.Contains(err.Error(), "CHECK constraint") {
+			e.Kind = ErrCheckViolation
+		}
+		return e.messageNames(sqlServerConflict)
+	case 515:
+		e.Kind = ErrNotNullViolation
+		return e.messageNames(sqlServerNull)
+	case 1205:
+		e.Kind = ErrDeadlock
+	case 3960:
+		e.Kind = ErrSerialization
+	case 1222:
+		e.Kind = ErrTimeout
+	case 233, 10053, 10054:
+		e.Kind = ErrConnection
+	default:
+		return nil
+	}
+	return e
+}
+
+// TxOptionsStatements sets the isolation level of the connection for the
+// transaction, and restores the default read committed level afterwards.
+// SQL Server has no read-only transactions.
+func (d SqlServerDialect) TxOptionsStatements(isolation sql.IsolationLevel, readOnly bool) (setup, reset []string, err error) {
+	if readOnly {
+		return nil, nil, fmt.Errorf("gorp: SQL Server does not support read-only transactions")
+	}
+	if isolation == sql.LevelDefault {
+		return nil, nil, nil
+	}
+	name := isolationName(isolation)
+	if name == "" {
+		return nil, nil, fmt.Errorf("gorp: SQL Server does not support isolation level %v", isolation)
+	}
+	return []string{"set transaction isolation level " + name + ";"},
+		[]string{"set transaction isolation level read committed;"}, nil
+}
@@ -1,7 +1,12 @@
 package gorp
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"reflect"
+	"regexp"
 )
 
 // A non-fatal error, when a select query returns columns that do not exist
@@ -24,3 +29,166 @@ func NonFatalError(err error) bool {
 		return false
 	}
 }
+
+// driverError returns the SQLSTATE and the vendor error number of a
+// driver error, or of the first error it wraps that has either.  They
+// are found without depending on the drivers, through the SQLState and
+// SQLErrorNumber methods or the Number and Code fields of the error:
+// lib/pq and pgx report a SQLSTATE, go-sql-driver/mysql, mssql and
+// go-sqlite3 a number.
+func driverError(err error) (state string, number int64, ok bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch e := err.(type) {
+		case interface{ SQLState() string }:
+			return e.SQLState(), 0, true
+		case interface{ SQLErrorNumber() int32 }:
+			return "", int64(e.SQLErrorNumber()), true
+		}
+		v := reflect.Indirect(reflect.ValueOf(err))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		for _, name := range []string{"Number", "Code"} {
+			f := v.FieldByName(name)
+			switch f.Kind() {
+			case reflect.String:
+				return f.String(), 0, true
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return "", f.Int(), true
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return "", int64(f.Uint()), true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// Classes of database errors.  Errors of the database that the dialect
+// recognizes are returned as a *DbError matching one of them:
+//
+//	err := dbmap.Insert(user)
+//	if errors.Is(err, gorp.ErrUniqueViolation) {
+//		return http.StatusConflict
+//	}
+var (
+	ErrUniqueViolation     = errors.New("gorp: unique violation")
+	ErrForeignKeyViolation = errors.New("gorp: foreign key violation")
+	ErrNotNullViolation    = errors.New("gorp: not null violation")
+	ErrCheckViolation      = errors.New("gorp: check violation")
+	ErrDeadlock            = errors.New("gorp: deadlock")
+	ErrSerialization       = errors.New("gorp: serialization failure")
+	ErrTimeout             = errors.New("gorp: timeout")
+	ErrConnection          = errors.New("gorp: connection failure")
+)
+
+// DbError is a database error classified by the dialect.  errors.Is
+// matches it with its Kind, and errors.As with the driver error it wraps.
+type DbError struct {
+	// Kind is one of the error classes, such as ErrUniqueViolation.
+	Kind error
+
+	// Names of the constraint, table and column the error is about, when
+	// the driver reports them.
+	Constraint string
+	Table      string
+	Column     string
+
+	// Err is the error of the driver.
+	Err error
+}
+
+func (e *DbError) Error() string {
+	return e.Err.Error()
+}
+
+// Is reports whether target is the class of e.
+func (e *DbError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the error of the driver.
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError returns err as a *DbError if the dialect recognizes it, or
+// if it is a timeout or a broken connection.  Other errors are returned
+// unchanged.
+func (m *DbMap) classifyError(err error) error {
+	var dbErr *DbError
+	if err == nil || errors.As(err, &dbErr) {
+		return err
+	}
+	if classifier, ok := m.Dialect.(ErrorClassifier); ok {
+		if dbErr = classifier.ClassifyError(err); dbErr != nil {
+			return dbErr
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &DbError{Kind: ErrTimeout, Err: err}
+	case errors.Is(err, driver.ErrBadConn):
+		return &DbError{Kind: ErrConnection, Err: err}
+	}
+	return err
+}
+
+// classifyExecError classifies err with the DbMap exec runs on, for the
+// errors of rows the dialects and helpers read through an executor.
+func classifyExecError(exec SqlExecutor, err error) error {
+	switch ex := exec.(type) {
+	case *DbMap:
+		return ex.classifyError(err)
+	case *Transaction:
+		return ex.dbmap.classifyError(err)
+	}
+	return err
+}
+
+// driverField returns the field with one of the given names of the first
+// driver error in the chain of err that has one, as lib/pq and pgx report
+// the constraint, table and column of an error.
+func driverField(err error, names ...string) reflect.Value {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.Indirect(reflect.ValueOf(err))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		for _, name := range names {
+			if f := v.FieldByName(name); f.IsValid() {
+				return f
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+// driverString returns the string field with one of the given names of
+// the driver error in err, or "".
+func driverString(err error, names ...string) string {
+	if f := driverField(err, names...); f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// messageNames sets the names of e to the submatches of re in the message
+// of its error that are named constraint, table and column.  Drivers that
+// report no names put them in their messages.
+func (e *DbError) messageNames(re *regexp.Regexp) *DbError {
+	match := re.FindStringSubmatch(e.Err.Error())
+	if match == nil {
+		return e
+	}
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "constraint":
+			e.Constraint = match[i]
+		case "table":
+			e.Table = match[i]
+		case "column":
+			e.Column = match[i]
+		}
+	}
+	return e
+}
@@ -0,0 +1,205 @@
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyMap represents a foreign key constraint from columns of a table
+// to columns of another table.
+// Foreign keys only inform the CreateTables() function, the order in
+// which CreateTables() and DropTables() process tables, and the keys of
+// relations loaded by Preload().
+type ForeignKeyMap struct {
+	// Constraint name in db table.  If empty, "fk_<table>_<columns>" is
+	// used.
+	ConstraintName string
+
+	// Referenced table and, if it is not in the default schema, its
+	// schema.
+	RefSchema string
+	RefTable  string
+
+	// Referenced column names.  Struct field names are accepted if the
+	// referenced table is mapped.
+	RefColumns []string
+
+	// Referential actions, such as "cascade", "set null" or
+	// "restrict".  Left out of the constraint if empty.
+	// Oracle does not support OnUpdate.
+	OnDelete string
+	OnUpdate string
+
+	table   *TableMap
+	columns []*ColumnMap
+}
+
+// Rename allows you to specify the constraint name.
+func (fk *ForeignKeyMap) Rename(name string) *ForeignKeyMap {
+	fk.ConstraintName = name
+	return fk
+}
+
+// SetOnDelete sets the action taken when a referenced row is deleted.
+func (fk *ForeignKeyMap) SetOnDelete(action string) *ForeignKeyMap {
+	fk.OnDelete = action
+	return fk
+}
+
+// SetOnUpdate sets the action taken when a referenced key is updated.
+func (fk *ForeignKeyMap) SetOnUpdate(action string) *ForeignKeyMap {
+	fk.OnUpdate = action
+	return fk
+}
+
+// Columns returns the names of the referencing columns.
+func (fk *ForeignKeyMap) Columns() []string {
+	names := make([]string, len(fk.columns))
+	for i, col := range fk.columns {
+		names[i] = col.ColumnName
+	}
+	return names
+}
+
+// refTableMap returns the mapped referenced table, or nil if it is not
+// mapped.
+func (fk *ForeignKeyMap) refTableMap() *TableMap {
+	for _, t := range fk.table.dbmap.tables {
+		if strings.EqualFold(t.TableName, fk.RefTable) && strings.EqualFold(t.SchemaName, fk.RefSchema) {
+			return t
+		}
+	}
+	return nil
+}
+
+// refColumnNames returns the referenced column names, translating field
+// names of a mapped referenced table.
+func (fk *ForeignKeyMap) refColumnNames() []string {
+	names := append([]string(nil), fk.RefColumns...)
+	if ref := fk.refTableMap(); ref != nil {
+		for i, name := range names {
+			if col := colMapOrNil(ref, name); col != nil {
+				names[i] = col.ColumnName
+			}
+		}
+	}
+	return names
+}
+
+// name returns the constraint name, generating it if ConstraintName is
+// empty.
+func (fk *ForeignKeyMap) name() string {
+	if fk.ConstraintName != "" {
+		return fk.ConstraintName
+	}
+	return fmt.Sprintf("fk_%s_%s", fk.table.TableName, strings.Join(fk.Columns(), "_"))
+}
+
+// sqlForConstraint returns the constraint clause of a "create table"
+// statement.
+func (fk *ForeignKeyMap) sqlForConstraint() string {
+	dialect := fk.table.dbmap.Dialect
+	cols := fk.Columns()
+
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("constraint %s foreign key (", dialect.QuoteField(fk.name())))
+	for i, col := range cols {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(dialect.QuoteField(col))
+	}
+	s.WriteString(fmt.Sprintf(") references %s (", dialect.QuotedTableForQuery(fk.RefSchema, fk.RefTable)))
+	for i, col := range fk.refColumnNames() {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(dialect.QuoteField(col))
+	}
+	s.WriteString(")")
+	if fk.OnDelete != "" {
+		s.WriteString(" on delete " + fk.OnDelete)
+	}
+	if fk.OnUpdate != "" {
+		s.WriteString(" on update " + fk.OnUpdate)
+	}
+	return s.String()
+}
+
+func newForeignKeyMap(refTable string, refColumns []string) *ForeignKeyMap {
+	fk := &ForeignKeyMap{RefTable: refTable, RefColumns: refColumns}
+	if dot := strings.LastIndex(refTable, "."); dot >= 0 {
+		fk.RefSchema, fk.RefTable = refTable[:dot], refTable[dot+1:]
+	}
+	return fk
+}
+
+// AddForeignKey registers a foreign key from the columns of fieldNames to
+// refColumns of refTable.  refTable is the name of the referenced table;
+// qualify it as "schema.table" if it is not in the default schema.
+//
+// Panics if a field does not exist, or if fieldNames and refColumns
+// differ in length.
+func (t *TableMap) AddForeignKey(fieldNames []string, refTable string, refColumns []string) *ForeignKeyMap {
+	t.checkFrozen("AddForeignKey")
+	if len(fieldNames) == 0 || len(fieldNames) != len(refColumns) {
+		panic(fmt.Sprintf(
+			"gorp: AddForeignKey: fieldNames and refColumns must have the same non-zero length (got %d and %d)",
+			len(fieldNames), len(refColumns)))
+	}
+	fk := newForeignKeyMap(refTable, refColumns)
+	fk.table = t
+	for _, name := range fieldNames {
+		fk.columns = append(fk.columns, t.ColMap(name))
+	}
+	t.foreignKeys = append(t.foreignKeys, fk)
+	return fk
+}
+
+// ForeignKeys returns the foreign keys of the table, those set on its
+// columns with ColumnMap.SetForeignKey followed by those added with
+// AddForeignKey.
+func (t *TableMap) ForeignKeys() []*ForeignKeyMap {
+	var fks []*ForeignKeyMap
+	for _, col := range t.Columns {
+		if col.foreignKey != nil && !col.Transient {
+			fks = append(fks, col.foreignKey)
+		}
+	}
+	return append(fks, t.foreignKeys...)
+}
+
+// dependencyOrder returns the tables ordered so that every table comes
+// after the tables its foreign keys reference.  Tables keep their
+// registration order otherwise.  The foreign keys closing a cycle between
+// tables cannot follow that order and are returned as cycles; a table
+// referencing itself does not form a cycle.
+func dependencyOrder(tables []*TableMap) (ordered []*TableMap, cycles map[*ForeignKeyMap]bool) {
+	ordered = make([]*TableMap, 0, len(tables))
+	cycles = make(map[*ForeignKeyMap]bool)
+	state := make(map[*TableMap]int) // 1: visiting, 2: done
+	var visit func(t *TableMap)
+	visit = func(t *TableMap) {
+		if state[t] != 0 {
+			return
+		}
+		state[t] = 1
+		for _, fk := range t.ForeignKeys() {
+			ref := fk.refTableMap()
+			if ref == nil || ref == t {
+				continue
+			}
+			if state[ref] == 1 {
+				cycles[fk] = true
+			}
+			visit(ref)
+		}
+		state[t] = 2
+		ordered = append(ordered, t)
+	}
+	for _, t := range tables {
+		visit(t)
+	}
+	return ordered, cycles
+}
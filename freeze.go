@@ -0,0 +1,61 @@
+package gorp
+
+import "fmt"
+
+// Freeze ends the mapping of the DbMap.  It generates the statements of
+// every table up front, instead of on first use, and makes any further
+// change to the mapping through the methods of DbMap, TableMap and
+// ColumnMap panic, since such changes are not safe while the DbMap is
+// used by several goroutines.
+//
+// Call Freeze once all tables are mapped, before sharing the DbMap:
+//
+//	dbmap.AddTableWithName(Invoice{}, "invoice").SetKeys(true, "Id")
+//	dbmap.Freeze()
+func (m *DbMap) Freeze() {
+	for _, t := range m.tables {
+		t.generatePlans()
+	}
+	m.frozen = true
+}
+
+// Frozen reports whether Freeze was called.
+func (m *DbMap) Frozen() bool {
+	return m.frozen
+}
+
+// checkFrozen panics if the DbMap is frozen.  what names the change.
+func (m *DbMap) checkFrozen(what string) {
+	if m.frozen {
+		panic(fmt.Sprintf("gorp: %s: the DbMap is frozen", what))
+	}
+}
+
+func (t *TableMap) checkFrozen(what string) {
+	t.dbmap.checkFrozen(what)
+}
+
+func (c *ColumnMap) checkFrozen(what string) {
+	if c.table != nil {
+		c.table.checkFrozen(what)
+	}
+}
+
+// generatePlans generates and caches the plans of all statements the
+// table supports.
+func (t *TableMap) generatePlans() {
+	t.insertBindPlan()
+	if len(t.keys) > 0 {
+		t.bindGet(true)
+		t.updateBindPlan()
+		t.deleteBindPlan()
+		if t.softDelete != nil {
+			t.bindGet(false)
+			t.softDeleteBindPlan(true)
+			t.softDeleteBindPlan(false)
+		}
+	}
+	// Tables without conflict columns or dialects without upserts cannot
+	// upsert; the error is reported by Upsert.
+	t.upsertBindPlan()
+}
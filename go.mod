@@ -0,0 +1,12 @@
+module github.com/go-gorp/gorp
+
+go 1.21
+
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/ziutek/mymysql v1.5.4
+)
+
+require filippo.io/edwards25519 v1.1.0 // indirect
@@ -12,6 +12,7 @@
 package gorp
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
@@ -82,10 +83,13 @@ type TypeConverter interface {
 	FromDb(target interface{}) (CustomScanner, bool)
 }
 
-// Executor exposes the sql.DB and sql.Tx Exec function so that it can be used
-// on internal functions that convert named parameters for the Exec function.
+// Executor exposes the sql.DB and sql.Tx functions running statements, so
+// that they can be used on internal functions that run statements of
+// either.
 type executor interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // SqlExecutor exposes gorp operations that can be run from Pre/Post
@@ -94,7 +98,17 @@ type executor interface {
 //
 // See the DbMap function docs for each of the functions below for more
 // information.
+//
+// Hooks receive the SqlExecutor that triggered them, so any context set
+// with WithContext is carried into statements run from within a hook.
 type SqlExecutor interface {
+	WithContext(ctx context.Context) SqlExecutor
+	Context() context.Context
+	WithDeleted() SqlExecutor
+	Primary() SqlExecutor
+	Begin() (*Transaction, error)
+	OnCommit(fn func())
+	OnRollback(fn func())
 	Get(i interface{}, keys ...interface{}) (interface{}, error)
 	Insert(list ...interface{}) error
 	Update(list ...interface{}) (int64, error)
@@ -109,8 +123,25 @@ type SqlExecutor interface {
 	SelectStr(query string, args ...interface{}) (string, error)
 	SelectNullStr(query string, args ...interface{}) (sql.NullString, error)
 	SelectOne(holder interface{}, query string, args ...interface{}) error
+	SelectIter(i interface{}, query string, args ...interface{}) (*SelectIterator, error)
+	Query(i interface{}) *Query
+	GetContext(ctx context.Context, i interface{}, keys ...interface{}) (interface{}, error)
+	InsertContext(ctx context.Context, list ...interface{}) error
+	UpdateContext(ctx context.Context, list ...interface{}) (int64, error)
+	DeleteContext(ctx context.Context, list ...interface{}) (int64, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	SelectContext(ctx context.Context, i interface{}, query string,
+		args ...interface{}) ([]interface{}, error)
+	SelectIntContext(ctx context.Context, query string, args ...interface{}) (int64, error)
+	SelectNullIntContext(ctx context.Context, query string, args ...interface{}) (sql.NullInt64, error)
+	SelectFloatContext(ctx context.Context, query string, args ...interface{}) (float64, error)
+	SelectNullFloatContext(ctx context.Context, query string, args ...interface{}) (sql.NullFloat64, error)
+	SelectStrContext(ctx context.Context, query string, args ...interface{}) (string, error)
+	SelectNullStrContext(ctx context.Context, query string, args ...interface{}) (sql.NullString, error)
+	SelectOneContext(ctx context.Context, holder interface{}, query string, args ...interface{}) error
+	SelectIterContext(ctx context.Context, i interface{}, query string, args ...interface{}) (*SelectIterator, error)
 	query(query string, args ...interface{}) (*sql.Rows, error)
-	queryRow(query string, args ...interface{}) *sql.Row
+	queryRow(query string, args ...interface{}) row
 }
 
 // Compile-time check that DbMap and Transaction implement the SqlExecutor
@@ -145,13 +176,10 @@ func argsString(args ...interface{}) string {
 // query arguments first.
 func exec(e SqlExecutor, query string, args ...interface{}) (sql.Result, error) {
 	var dbMap *DbMap
-	var executor executor
 	switch m := e.(type) {
 	case *DbMap:
-		executor = m.Db
 		dbMap = m
 	case *Transaction:
-		executor = m.tx
 		dbMap = m.dbmap
 	}
 
@@ -159,7 +187,8 @@ func exec(e SqlExecutor, query string, args ...interface{}) (sql.Result, error)
 		query, args = maybeExpandNamedQuery(dbMap, query, args)
 	}
 
-	return executor.Exec(query, args...)
+	res := runStatement(e, ExecStatement, query, args)
+	return res.Result, res.Err
 }
 
 // maybeExpandNamedQuery checks the given arg to see if it's eligible to be used
@@ -339,7 +368,7 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 		return nil, err
 	}
 
-	plan := table.bindGet()
+	plan := table.bindGet(includeDeleted(exec))
 
 	v := reflect.New(t)
 	dest := make([]interface{}, len(plan.argFields))
@@ -360,7 +389,8 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 		dest[x] = target
 	}
 
-	row := exec.queryRow(plan.query, keys...)
+	args := append(append([]interface{}(nil), keys...), plan.condArgs...)
+	row := withTable(exec, table, "get", plan.keySensitive).queryRow(plan.query, args...)
 	err = row.Scan(dest...)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -387,6 +417,16 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 }
 
 func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	return deleteRows(m, exec, false, list)
+}
+
+func hardDelete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	return deleteRows(m, exec, true, list)
+}
+
+// deleteRows deletes the rows of list, only setting the soft delete marker
+// of tables that have one unless hard is true.
+func deleteRows(m *DbMap, exec SqlExecutor, hard bool, list []interface{}) (int64, error) {
 	count := int64(0)
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, true)
@@ -402,23 +442,36 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			}
 		}
 
-		bi, err := table.bindDelete(elem)
-		if err != nil {
-			return -1, err
-		}
+		var rows int64
+		if table.softDelete != nil && !hard {
+			marker := table.softDeleteMarker(true)
+			bi, err := table.bindSoftDelete(elem, marker, true)
+			if err != nil {
+				return -1, err
+			}
+			rows, err = execSoftDelete(m, exec, table, elem, bi, marker, true)
+			if err != nil {
+				return -1, err
+			}
+		} else {
+			bi, err := table.bindDelete(elem)
+			if err != nil {
+				return -1, err
+			}
 
-		res, err := exec.Exec(bi.query, bi.args...)
-		if err != nil {
-			return -1, err
-		}
-		rows, err := res.RowsAffected()
-		if err != nil {
-			return -1, err
-		}
+			res, err := withTable(exec, table, "delete", bi.sensitive).Exec(bi.query, bi.args...)
+			if err != nil {
+				return -1, err
+			}
+			rows, err = res.RowsAffected()
+			if err != nil {
+				return -1, err
+			}
 
-		if rows == 0 && bi.existingVersion > 0 {
-			return lockError(m, exec, table.TableName,
-				bi.existingVersion, elem, bi.keys...)
+			if rows == 0 && bi.existingVersion > 0 {
+				return lockError(m, exec, table.TableName,
+					bi.existingVersion, elem, bi.keys...)
+			}
 		}
 
 		count += rows
@@ -442,6 +495,7 @@ func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
+		table.setTimestamps(elem, false)
 		eval := elem.Addr().Interface()
 		if v, ok := eval.(HasPreUpdate); ok {
 			err = v.PreUpdate(exec)
@@ -455,7 +509,7 @@ func update(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return -1, err
 		}
 
-		res, err := exec.Exec(bi.query, bi.args...)
+		res, err := withTable(exec, table, "update", bi.sensitive).Exec(bi.query, bi.args...)
 		if err != nil {
 			return -1, err
 		}
@@ -493,6 +547,7 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 			return err
 		}
 
+		table.setTimestamps(elem, true)
 		eval := elem.Addr().Interface()
 		if v, ok := eval.(HasPreInsert); ok {
 			err := v.PreInsert(exec)
@@ -506,24 +561,20 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 			return err
 		}
 
+		texec := withTable(exec, table, "insert", bi.sensitive)
 		if bi.autoIncrIdx > -1 {
 			f := elem.FieldByName(bi.autoIncrFieldName)
 			switch inserter := m.Dialect.(type) {
 			case IntegerAutoIncrInserter:
-				id, err := inserter.InsertAutoIncr(exec, bi.query, bi.args...)
+				id, err := inserter.InsertAutoIncr(texec, bi.query, bi.args...)
 				if err != nil {
 					return err
 				}
-				k := f.Kind()
-				if (k == reflect.Int) || (k == reflect.Int16) || (k == reflect.Int32) || (k == reflect.Int64) {
-					f.SetInt(id)
-				} else if (k == reflect.Uint) || (k == reflect.Uint16) || (k == reflect.Uint32) || (k == reflect.Uint64) {
-					f.SetUint(uint64(id))
-				} else {
+				if !setIntValue(f, id) {
 					return fmt.Errorf("gorp: Cannot set autoincrement value on non-Int field. SQL=%s  autoIncrIdx=%d autoIncrFieldName=%s", bi.query, bi.autoIncrIdx, bi.autoIncrFieldName)
 				}
 			case TargetedAutoIncrInserter:
-				err := inserter.InsertAutoIncrToTarget(exec, bi.query, f.Addr().Interface(), bi.args...)
+				err := inserter.InsertAutoIncrToTarget(texec, bi.query, f.Addr().Interface(), bi.args...)
 				if err != nil {
 					return err
 				}
@@ -532,7 +583,7 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 				if idQuery == "" {
 					return fmt.Errorf("gorp: Cannot set %s value if its ColumnMap.GeneratedIdQuery is empty", bi.autoIncrFieldName)
 				}
-				err := inserter.InsertQueryToTarget(exec, bi.query, idQuery, f.Addr().Interface(), bi.args...)
+				err := inserter.InsertQueryToTarget(texec, bi.query, idQuery, f.Addr().Interface(), bi.args...)
 				if err != nil {
 					return err
 				}
@@ -540,7 +591,7 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 				return fmt.Errorf("gorp: Cannot use autoincrement fields on dialects that do not implement an autoincrementing interface")
 			}
 		} else {
-			_, err := exec.Exec(bi.query, bi.args...)
+			_, err := texec.Exec(bi.query, bi.args...)
 			if err != nil {
 				return err
 			}
@@ -555,3 +606,164 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 	}
 	return nil
 }
+
+func insertBatch(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+	batcher, ok := m.Dialect.(BatchInserter)
+	if !ok {
+		return insert(m, exec, list...)
+	}
+
+	// Group the elements by table, keeping the order in which the tables
+	// first appear in list.
+	var tables []*TableMap
+	groups := make(map[*TableMap][]reflect.Value)
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, false)
+		if err != nil {
+			return err
+		}
+		if _, ok := groups[table]; !ok {
+			tables = append(tables, table)
+		}
+		groups[table] = append(groups[table], elem)
+	}
+
+	inserter, canBindKeys := m.Dialect.(BatchAutoIncrInserter)
+	for _, table := range tables {
+		elems := groups[table]
+		plan := table.insertBindPlan()
+
+		if plan.autoIncrIdx > -1 && !canBindKeys {
+			// The generated keys could not be bound back to the
+			// elements, so insert them one at a time.
+			for _, elem := range elems {
+				err := insert(m, exec, elem.Addr().Interface())
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		rows := len(elems)
+		if len(plan.argFields) > 0 {
+			rows = batcher.MaxBindVars() / len(plan.argFields)
+		}
+		if max := batcher.MaxInsertRows(); max > 0 && rows > max {
+			rows = max
+		}
+		if rows < 1 {
+			rows = 1
+		}
+
+		for len(elems) > 0 {
+			n := rows
+			if n > len(elems) {
+				n = len(elems)
+			}
+			err := insertRows(table, exec, inserter, elems[:n])
+			if err != nil {
+				return err
+			}
+			elems = elems[n:]
+		}
+	}
+	return nil
+}
+
+// insertRows inserts elems, which all belong to table, with one multi-row
+// insert statement.
+func insertRows(table *TableMap, exec SqlExecutor, inserter BatchAutoIncrInserter, elems []reflect.Value) error {
+	for _, elem := range elems {
+		table.setTimestamps(elem, true)
+		if v, ok := elem.Addr().Interface().(HasPreInsert); ok {
+			err := v.PreInsert(exec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	bi, err := table.bindInsertBatch(elems)
+	if err != nil {
+		return err
+	}
+
+	texec := withTable(exec, table, "insert", bi.sensitive)
+	if bi.autoIncrIdx > -1 {
+		targets := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			targets[i] = elem.FieldByName(bi.autoIncrFieldName).Addr().Interface()
+		}
+		err = inserter.InsertBatchAutoIncr(texec, bi.query, targets, bi.args...)
+	} else {
+		_, err = texec.Exec(bi.query, bi.args...)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range elems {
+		if v, ok := elem.Addr().Interface().(HasPostInsert); ok {
+			err := v.PostInsert(exec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func upsert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, false)
+		if err != nil {
+			return err
+		}
+
+		table.setTimestamps(elem, true)
+		eval := elem.Addr().Interface()
+		if v, ok := eval.(HasPreInsert); ok {
+			err := v.PreInsert(exec)
+			if err != nil {
+				return err
+			}
+		}
+		if v, ok := eval.(HasPreUpdate); ok {
+			err := v.PreUpdate(exec)
+			if err != nil {
+				return err
+			}
+		}
+
+		plan, err := table.upsertBindPlan()
+		if err != nil {
+			return err
+		}
+		bi, err := plan.createBindInstance(elem, m.TypeConverter)
+		if err != nil {
+			return err
+		}
+
+		texec := withTable(exec, table, "upsert", bi.sensitive)
+		_, err = texec.Exec(bi.query, bi.args...)
+		if err != nil {
+			return err
+		}
+
+		if plan.fetchQuery != "" {
+			// Read back the generated key and the version, which differ
+			// from the bound values if an existing row was updated.
+			dest := make([]interface{}, len(plan.fetchFields))
+			for x, fieldName := range plan.fetchFields {
+				dest[x] = elem.FieldByName(fieldName).Addr().Interface()
+			}
+			fetch := withTable(exec, table, "upsert", plan.keySensitive)
+			err = fetch.queryRow(plan.fetchQuery, bi.keys...).Scan(dest...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
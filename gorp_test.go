@@ -2,6 +2,7 @@ package gorp
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"reflect"
@@ -38,7 +40,11 @@ var (
 
 func init() {
 	flag.BoolVar(&debug, "trace", true, "Turn on or off database tracing (DbMap.TraceOn)")
+}
+
+func TestMain(m *testing.M) {
 	flag.Parse()
+	os.Exit(m.Run())
 }
 
 type testable interface {
@@ -71,6 +77,19 @@ func (me *Invoice) Rand() {
 	me.Updated = rand.Int63()
 }
 
+type Department struct {
+	Id        int64
+	Name      string
+	ManagerId sql.NullInt64
+}
+
+type Employee struct {
+	Id           int64
+	Name         string
+	DepartmentId int64
+	BossId       sql.NullInt64
+}
+
 type InvoiceTag struct {
 	Id       int64 `db:"myid, primarykey, autoincrement"`
 	Created  int64 `db:"myCreated"`
@@ -251,6 +270,163 @@ type UniqueColumns struct {
 	ZipCode   int64
 }
 
+type RelPerson struct {
+	Id       int64
+	Name     string
+	Invoices []*RelInvoice `db:"-"`
+}
+
+type RelInvoice struct {
+	Id       int64
+	PersonId int64
+	Memo     string
+	Person   *RelPerson `db:"-"`
+	Tags     []RelTag   `db:"-"`
+	Detail   *RelDetail `db:"-"`
+}
+
+type RelTag struct {
+	Id        int64
+	InvoiceId int64
+	Name      string
+}
+
+type RelDetail struct {
+	Id        int64
+	InvoiceId int64
+	Text      string
+}
+
+type SoftPerson struct {
+	Id        int64
+	Name      string
+	DeletedAt *time.Time
+	Version   int64
+}
+
+type SoftFlag struct {
+	Id      int64
+	Name    string
+	Deleted bool
+}
+
+type Stamped struct {
+	Id      int64
+	Name    string
+	Created time.Time  `db:"created_at,created"`
+	Updated *time.Time `db:",updated"`
+}
+
+type Account struct {
+	Id       int64
+	Login    string
+	Password string `db:",sensitive"`
+}
+
+// Notice records the outcome of the transaction it was inserted in.
+type Notice struct {
+	Id     int64
+	Msg    string
+	Events *[]string `db:"-"`
+}
+
+func (n *Notice) PostInsert(s SqlExecutor) error {
+	s.OnCommit(func() { *n.Events = append(*n.Events, "commit "+n.Msg) })
+	s.OnRollback(func() { *n.Events = append(*n.Events, "rollback "+n.Msg) })
+	return nil
+}
+
+// Outbox records the outcome of inserting it, known before the insert.
+type Outbox struct {
+	Id     int64
+	Msg    string
+	Events *[]string `db:"-"`
+}
+
+func (o *Outbox) PreInsert(s SqlExecutor) error {
+	s.OnCommit(func() { *o.Events = append(*o.Events, "commit "+o.Msg) })
+	s.OnRollback(func() { *o.Events = append(*o.Events, "rollback "+o.Msg) })
+	return nil
+}
+
+type Member struct {
+	Id    int64
+	Email string
+	Name  *string
+}
+
+// Gadget declares its schema with tag options.
+type Gadget struct {
+	Id    int64
+	Sku   string  `db:"sku,type:varchar(20),notnull,uniqueindex"`
+	Shelf string  `db:"shelf,index:gadget_place"`
+	Bin   string  `db:"bin,index:gadget_place"`
+	Maker string  `db:"maker,uniqueindex:gadget_maker_model"`
+	Model string  `db:"model,uniqueindex:gadget_maker_model"`
+	Qty   int64   `db:"qty,check:qty >= 0,comment:units in stock"`
+	Price float64 `db:"price,type:decimal(10,2),unique"`
+	Note  *string `db:"note,readonly"`
+	Cache string  `db:"cache,transient"`
+}
+
+// Errors shaped like those of the database drivers, which are not
+// imported by the dialects.
+type sqlStateError struct{ state string }
+
+func (e *sqlStateError) Error() string    { return "sqlstate " + e.state }
+func (e *sqlStateError) SQLState() string { return e.state }
+
+type numberError struct {
+	Number uint16
+}
+
+func (e *numberError) Error() string { return fmt.Sprintf("error %d", e.Number) }
+
+type codeError struct {
+	Code int
+}
+
+func (e codeError) Error() string { return fmt.Sprintf("code %d", e.Code) }
+
+type pqError struct {
+	Code       string
+	Constraint string
+	Table      string
+	Column     string
+}
+
+func (e *pqError) Error() string { return "pq: " + e.Code }
+
+type mysqlError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *mysqlError) Error() string { return fmt.Sprintf("Error %d: %s", e.Number, e.Message) }
+
+type sqliteError struct {
+	Code         int
+	ExtendedCode int
+	msg          string
+}
+
+func (e sqliteError) Error() string { return e.msg }
+
+type countingLogger struct {
+	count int
+}
+
+func (l *countingLogger) Printf(format string, v ...interface{}) {
+	l.count++
+}
+
+type UpsertItem struct {
+	Id      int64
+	Sku     string
+	Name    string
+	Version int64
+}
+
 type SingleColumnTable struct {
 	SomeId string
 }
@@ -900,13 +1076,13 @@ func TestNullValues(t *testing.T) {
 	}
 
 	// update it
-	t1.Str = sql.NullString{"hi", true}
+	t1.Str = sql.NullString{String: "hi", Valid: true}
 	expected.Str = t1.Str
-	t1.Int64 = sql.NullInt64{999, true}
+	t1.Int64 = sql.NullInt64{Int64: 999, Valid: true}
 	expected.Int64 = t1.Int64
-	t1.Float64 = sql.NullFloat64{53.33, true}
+	t1.Float64 = sql.NullFloat64{Float64: 53.33, Valid: true}
 	expected.Float64 = t1.Float64
-	t1.Bool = sql.NullBool{true, true}
+	t1.Bool = sql.NullBool{Bool: true, Valid: true}
 	expected.Bool = t1.Bool
 	t1.Bytes = []byte{1, 30, 31, 33}
 	expected.Bytes = t1.Bytes
@@ -1151,6 +1327,212 @@ func TestSavepoint(t *testing.T) {
 	}
 }
 
+func TestNestedTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	// setMemo is written once against SqlExecutor.
+	setMemo := func(exec SqlExecutor, inv *Invoice, memo string, commit bool) {
+		tx, err := exec.Begin()
+		if err != nil {
+			panic(err)
+		}
+		inv.Memo = memo
+		if _, err := tx.Update(inv); err != nil {
+			panic(err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			panic(err)
+		}
+		if err := tx.Commit(); err != sql.ErrTxDone {
+			t.Errorf("Commit of a closed transaction: %v", err)
+		}
+	}
+
+	inv := &Invoice{0, 100, 200, "unpaid", 0, false}
+	_insert(dbmap, inv)
+	setMemo(dbmap, inv, "outside", true)
+	if got := _get(dbmap, Invoice{}, inv.Id).(*Invoice).Memo; got != "outside" {
+		t.Errorf("memo = %q, want outside", got)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	checkMemo := func(want string) {
+		memo, err := trans.SelectStr("select memo from invoice_test")
+		if err != nil {
+			panic(err)
+		}
+		if memo != want {
+			t.Errorf("memo = %q, want %q", memo, want)
+		}
+	}
+
+	setMemo(trans, inv, "released", true)
+	checkMemo("released")
+	setMemo(trans, inv, "rolled back", false)
+	checkMemo("released")
+
+	child, err := trans.Begin()
+	if err != nil {
+		panic(err)
+	}
+	setMemo(child, inv, "grandchild", true)
+	if err := child.Rollback(); err != nil {
+		t.Errorf("Rollback: %v", err)
+	}
+	checkMemo("released")
+
+	if err := trans.Commit(); err != nil {
+		panic(err)
+	}
+	if _, err := trans.Begin(); err != sql.ErrTxDone {
+		t.Errorf("Begin in a closed transaction: %v", err)
+	}
+	if got := _get(dbmap, Invoice{}, inv.Id).(*Invoice).Memo; got != "released" {
+		t.Errorf("memo = %q, want released", got)
+	}
+}
+
+func TestTransactionCopiesShareState(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err := trans.Rollback(); err != sql.ErrTxDone {
+			t.Errorf("Rollback after a copy committed: %v", err)
+		}
+	}()
+	if err := trans.Insert(&Invoice{Memo: "copied"}); err != nil {
+		panic(err)
+	}
+	if err := trans.WithContext(context.Background()).(*Transaction).Commit(); err != nil {
+		t.Errorf("Commit: %v", err)
+	}
+	if err := trans.WithDeleted().(*Transaction).Commit(); err != sql.ErrTxDone {
+		t.Errorf("Commit of another copy: %v", err)
+	}
+	if n, err := dbmap.SelectInt("select count(*) from invoice_test"); err != nil || n != 1 {
+		t.Errorf("count = %d, %v, want 1", n, err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if exec := dbmap.WithContext(ctx); exec.Context() != ctx {
+		t.Errorf("WithContext did not carry the context")
+	}
+
+	inv1 := &Invoice{0, 100, 200, "ctx", 0, false}
+	err := dbmap.InsertContext(ctx, inv1)
+	if err != nil {
+		panic(err)
+	}
+	obj, err := dbmap.GetContext(ctx, Invoice{}, inv1.Id)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(inv1, obj) {
+		t.Errorf("%v != %v", inv1, obj)
+	}
+
+	trans, err := dbmap.BeginTx(ctx, nil)
+	if err != nil {
+		panic(err)
+	}
+	count, err := trans.SelectIntContext(ctx, "select count(*) from invoice_test")
+	if err != nil {
+		panic(err)
+	}
+	if count != 1 {
+		t.Errorf("count %d != 1", count)
+	}
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	cancel()
+	err = dbmap.InsertContext(ctx, &Invoice{0, 100, 200, "canceled", 0, false})
+	if err == nil {
+		t.Errorf("InsertContext with a canceled context should fail")
+	}
+	_, err = dbmap.BeginTx(ctx, nil)
+	if err == nil {
+		t.Errorf("BeginTx with a canceled context should fail")
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "batch1", 0, false}
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	inv2 := &Invoice{0, 100, 200, "batch2", 0, true}
+	p2 := &Person{0, 0, 0, "jane", "doe", 0}
+	err := dbmap.InsertBatch(inv1, p1, inv2, p2)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, want := range []interface{}{inv1, inv2} {
+		inv := want.(*Invoice)
+		if inv.Id == 0 {
+			t.Errorf("autoincrement id was not bound: %v", inv)
+		}
+		obj := _get(dbmap, Invoice{}, inv.Id)
+		if !reflect.DeepEqual(inv, obj) {
+			t.Errorf("%v != %v", inv, obj)
+		}
+	}
+	if inv1.Id == inv2.Id {
+		t.Errorf("batch inserted rows share id %d", inv1.Id)
+	}
+	for _, p := range []*Person{p1, p2} {
+		if p.Version != 1 || p.LName != "postinsert" || p.Created == 0 {
+			t.Errorf("hooks or version not applied to batch insert: %v", p)
+		}
+		obj := _get(dbmap, Person{}, p.Id).(*Person)
+		if obj.FName != p.FName || obj.Version != 1 {
+			t.Errorf("%v != %v", p, obj)
+		}
+	}
+
+	// enough rows to be split across several statements on sqlite
+	list := make([]interface{}, 0, 500)
+	for i := 0; i < cap(list); i++ {
+		list = append(list, &Invoice{0, int64(i), 200, "bulk", 0, false})
+	}
+	err = dbmap.InsertBatch(list...)
+	if err != nil {
+		panic(err)
+	}
+	count := selectInt(dbmap, "select count(*) from invoice_test where memo = 'bulk'")
+	if count != int64(len(list)) {
+		t.Errorf("inserted %d rows, want %d", count, len(list))
+	}
+	last := list[len(list)-1].(*Invoice)
+	obj := _get(dbmap, Invoice{}, last.Id).(*Invoice)
+	if obj.Created != last.Created {
+		t.Errorf("last batch row bound to id %d with created %d, want %d", last.Id, obj.Created, last.Created)
+	}
+}
+
 func TestMultiple(t *testing.T) {
 	dbmap := initDbMap()
 	defer dropAndClose(dbmap)
@@ -1406,10 +1788,10 @@ func TestSelectVal(t *testing.T) {
 
 	bindVar := dbmap.Dialect.BindVar(0)
 
-	t1 := TableWithNull{Str: sql.NullString{"abc", true},
-		Int64:   sql.NullInt64{78, true},
-		Float64: sql.NullFloat64{32.2, true},
-		Bool:    sql.NullBool{true, true},
+	t1 := TableWithNull{Str: sql.NullString{String: "abc", Valid: true},
+		Int64:   sql.NullInt64{Int64: 78, Valid: true},
+		Float64: sql.NullFloat64{Float64: 32.2, Valid: true},
+		Bool:    sql.NullBool{Bool: true, Valid: true},
 		Bytes:   []byte("hi")}
 	_insert(dbmap, &t1)
 
@@ -1429,37 +1811,37 @@ func TestSelectVal(t *testing.T) {
 
 	// SelectNullInt
 	n := selectNullInt(dbmap, "select Int64 from TableWithNull where Str='notfound'")
-	if !reflect.DeepEqual(n, sql.NullInt64{0, false}) {
+	if !reflect.DeepEqual(n, sql.NullInt64{Int64: 0, Valid: false}) {
 		t.Errorf("nullint %v != 0,false", n)
 	}
 
 	n = selectNullInt(dbmap, "select Int64 from TableWithNull where Str='abc'")
-	if !reflect.DeepEqual(n, sql.NullInt64{78, true}) {
+	if !reflect.DeepEqual(n, sql.NullInt64{Int64: 78, Valid: true}) {
 		t.Errorf("nullint %v != 78, true", n)
 	}
 
 	// SelectFloat
 	f64 := selectFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
 	if f64 != 32.2 {
-		t.Errorf("float64 %d != 32.2", f64)
+		t.Errorf("float64 %f != 32.2", f64)
 	}
 	f64 = selectFloat(dbmap, "select min(Float64) from TableWithNull")
 	if f64 != 32.2 {
-		t.Errorf("float64 min %d != 32.2", f64)
+		t.Errorf("float64 min %f != 32.2", f64)
 	}
 	f64 = selectFloat(dbmap, "select count(*) from TableWithNull where Str="+bindVar, "asdfasdf")
 	if f64 != 0 {
-		t.Errorf("float64 no rows %d != 0", f64)
+		t.Errorf("float64 no rows %f != 0", f64)
 	}
 
 	// SelectNullFloat
 	nf := selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='notfound'")
-	if !reflect.DeepEqual(nf, sql.NullFloat64{0, false}) {
+	if !reflect.DeepEqual(nf, sql.NullFloat64{Float64: 0, Valid: false}) {
 		t.Errorf("nullfloat %v != 0,false", nf)
 	}
 
 	nf = selectNullFloat(dbmap, "select Float64 from TableWithNull where Str='abc'")
-	if !reflect.DeepEqual(nf, sql.NullFloat64{32.2, true}) {
+	if !reflect.DeepEqual(nf, sql.NullFloat64{Float64: 32.2, Valid: true}) {
 		t.Errorf("nullfloat %v != 32.2, true", nf)
 	}
 
@@ -1475,11 +1857,11 @@ func TestSelectVal(t *testing.T) {
 
 	// SelectNullStr
 	ns := selectNullStr(dbmap, "select Str from TableWithNull where Int64="+bindVar, 78)
-	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
+	if !reflect.DeepEqual(ns, sql.NullString{String: "abc", Valid: true}) {
 		t.Errorf("nullstr %v != abc,true", ns)
 	}
 	ns = selectNullStr(dbmap, "select Str from TableWithNull where Str='asdfasdf'")
-	if !reflect.DeepEqual(ns, sql.NullString{"", false}) {
+	if !reflect.DeepEqual(ns, sql.NullString{String: "", Valid: false}) {
 		t.Errorf("nullstr no rows %v != '',false", ns)
 	}
 
@@ -1489,7 +1871,7 @@ func TestSelectVal(t *testing.T) {
 		t.Errorf("int64 %d != 78", i64)
 	}
 	ns = selectNullStr(dbmap, "select Str from TableWithNull where Int64=:num", map[string]int{"num": 78})
-	if !reflect.DeepEqual(ns, sql.NullString{"abc", true}) {
+	if !reflect.DeepEqual(ns, sql.NullString{String: "abc", Valid: true}) {
 		t.Errorf("nullstr %v != abc,true", ns)
 	}
 }
@@ -1513,67 +1895,1656 @@ func TestVersionMultipleRows(t *testing.T) {
 	}
 }
 
-func TestWithStringPk(t *testing.T) {
+func TestUpsert(t *testing.T) {
 	dbmap := newDbMap()
-	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(true, "Id")
-	_, err := dbmap.Exec("create table string_pk_test (Id varchar(255), Name varchar(255));")
+	table := dbmap.AddTableWithName(UpsertItem{}, "upsert_test").SetKeys(true, "Id")
+	table.ColMap("Sku").SetUnique(true)
+	table.SetVersionCol("Version")
+	err := dbmap.DropTablesIfExists()
 	if err != nil {
-		t.Errorf("couldn't create string_pk_test: %v", err)
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
 	}
 	defer dropAndClose(dbmap)
 
-	row := &WithStringPk{"1", "foo"}
-	err = dbmap.Insert(row)
-	if err == nil {
-		t.Errorf("Expected error when inserting into table w/non Int PK and autoincr set true")
+	i1 := &UpsertItem{0, "A1", "first", 0}
+	err = dbmap.Upsert(i1)
+	if err != nil {
+		panic(err)
+	}
+	if i1.Id == 0 || i1.Version != 1 {
+		t.Errorf("id and version not bound after insert: %v", i1)
 	}
-}
 
-// TestSqlExecutorInterfaceSelects ensures that all DbMap methods starting with Select...
-// are also exposed in the SqlExecutor interface. Select...  functions can always
-// run on Pre/Post hooks.
-func TestSqlExecutorInterfaceSelects(t *testing.T) {
-	dbMapType := reflect.TypeOf(&DbMap{})
-	sqlExecutorType := reflect.TypeOf((*SqlExecutor)(nil)).Elem()
-	numDbMapMethods := dbMapType.NumMethod()
-	for i := 0; i < numDbMapMethods; i += 1 {
-		dbMapMethod := dbMapType.Method(i)
-		if !strings.HasPrefix(dbMapMethod.Name, "Select") {
-			continue
-		}
-		if _, found := sqlExecutorType.MethodByName(dbMapMethod.Name); !found {
-			t.Errorf("Method %s is defined on DbMap but not implemented in SqlExecutor",
-				dbMapMethod.Name)
-		}
+	i2 := &UpsertItem{0, "A1", "second", 0}
+	err = dbmap.Upsert(i2)
+	if err != nil {
+		panic(err)
+	}
+	if i2.Id != i1.Id || i2.Version != 2 {
+		t.Errorf("upsert of existing sku: %v, want id %d and version 2", i2, i1.Id)
+	}
+
+	obj := _get(dbmap, UpsertItem{}, i1.Id).(*UpsertItem)
+	if !reflect.DeepEqual(i2, obj) {
+		t.Errorf("%v != %v", i2, obj)
+	}
+	count := selectInt(dbmap, "select count(*) from upsert_test")
+	if count != 1 {
+		t.Errorf("upsert created %d rows, want 1", count)
 	}
 }
 
-func TestNullTime(t *testing.T) {
-	dbmap := initDbMap()
-	defer dropAndClose(dbmap)
+func TestMigrator(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+
+	migrations := []*Migration{
+		{
+			Id: "0002_add_row",
+			Up: func(s SqlExecutor) error {
+				_, err := s.Exec("insert into migrate_test (id, name) values (1, 'one')")
+				return err
+			},
+			Down: func(s SqlExecutor) error {
+				_, err := s.Exec("delete from migrate_test where id = 1")
+				return err
+			},
+		},
+		{
+			Id:      "0001_create",
+			UpSql:   "create table migrate_test (id integer, name varchar(20));\n-- trailing comment\n",
+			DownSql: "drop table migrate_test;",
+		},
+	}
+	mg := NewMigrator(dbmap, migrations...)
+	defer func() {
+		dbmap.Exec("drop table migrate_test")
+		dbmap.Exec("drop table gorp_migrations")
+		dbmap.Exec("drop table gorp_migrations_lock")
+	}()
+	metrics := NewMemoryMetrics()
+	dbmap.SetMetrics(metrics)
 
-	// if time is null
-	ent := &WithNullTime{
-		Id: 0,
-		Time: NullTime{
-			Valid: false,
-		}}
-	err := dbmap.Insert(ent)
+	n, err := mg.Up()
 	if err != nil {
-		t.Error("failed insert on %s", err.Error())
+		panic(err)
 	}
-	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
-		"Id": ent.Id,
-	})
+	if n != 2 {
+		t.Errorf("applied %d migrations, want 2", n)
+	}
+	if m := metrics.Snapshot()[MetricsKey{"insert", "gorp_migrations"}]; m.Count != 2 {
+		t.Errorf("%d migration records measured, want 2", m.Count)
+	}
+	if count := selectInt(dbmap, "select count(*) from migrate_test"); count != 1 {
+		t.Errorf("migrate_test has %d rows, want 1", count)
+	}
+	n, err = mg.Up()
+	if err != nil || n != 0 {
+		t.Errorf("second Up applied %d migrations, err %v", n, err)
+	}
+
+	status, err := mg.Status()
 	if err != nil {
-		t.Error("failed select on %s", err.Error())
+		panic(err)
 	}
-	if ent.Time.Valid {
-		t.Error("NullTime returns valid but expected null.")
+	if len(status) != 2 || status[0].Migration.Id != "0001_create" || !status[0].Applied || !status[1].Applied {
+		t.Errorf("unexpected status: %+v", status)
 	}
 
-	// if time is not null
-	ts, err := time.Parse(time.Stamp, "Jan 2 15:04:05")
+	n, err = mg.Down(1)
+	if err != nil {
+		panic(err)
+	}
+	if n != 1 {
+		t.Errorf("reverted %d migrations, want 1", n)
+	}
+	if count := selectInt(dbmap, "select count(*) from migrate_test"); count != 0 {
+		t.Errorf("migrate_test has %d rows, want 0", count)
+	}
+
+	upSql := migrations[1].UpSql
+	migrations[1].UpSql = "create table migrate_test (id integer);"
+	_, err = mg.Up()
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+	migrations[1].UpSql = upSql
+
+	_rawexec(dbmap, "insert into gorp_migrations_lock (id, locked_at) values (1, 0)")
+	_, err = mg.Up()
+	if err != ErrMigrationLocked {
+		t.Errorf("expected ErrMigrationLocked, got %v", err)
+	}
+	err = mg.Unlock()
+	if err != nil {
+		panic(err)
+	}
+	n, err = mg.Up()
+	if err != nil || n != 1 {
+		t.Errorf("Up after Unlock applied %d migrations, err %v", n, err)
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	dbmap := newDbMap()
+	defer dbmap.Db.Close()
+
+	mg := NewMigrator(dbmap, &Migration{
+		Id: "0001_duplicate",
+		UpSql: "create table migrate_dup (id integer unique);\n" +
+			"insert into migrate_dup (id) values (1);\n" +
+			"insert into migrate_dup (id) values (1);",
+	})
+	defer func() {
+		dbmap.Exec("drop table migrate_dup")
+		dbmap.Exec("drop table gorp_migrations")
+		dbmap.Exec("drop table gorp_migrations_lock")
+	}()
+
+	_, err := mg.Up()
+	if !errors.Is(err, ErrUniqueViolation) || !strings.Contains(err.Error(), "0001_duplicate") {
+		t.Errorf("Up: %v", err)
+	}
+}
+
+func TestSplitSqlStatements(t *testing.T) {
+	sql := "create table a (x varchar(5) default ';');\n" +
+		"-- comment; with semicolon\n" +
+		"insert into a values ('b;c'); /* ; */ ;\n"
+	stmts := splitSqlStatements(sql)
+	want := []string{
+		"create table a (x varchar(5) default ';')",
+		"-- comment; with semicolon\ninsert into a values ('b;c')",
+		"/* ; */",
+	}
+	if !reflect.DeepEqual(stmts, want) {
+		t.Errorf("%q != %q", stmts, want)
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	dbmap := newDbMap()
+	table := dbmap.AddTableWithName(UpsertItem{}, "diff_test").SetKeys(true, "Id")
+	table.ColMap("Sku").SetUnique(true).SetMaxSize(40)
+	table.AddIndex("diff_test_name_idx", "Btree", []string{"Name"})
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateIndex()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	diffs, err := dbmap.DiffSchema()
+	if err != nil {
+		panic(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no differences after CreateTables, got %v", diffs)
+	}
+
+	_, err = dbmap.Exec("alter table diff_test add column extra integer")
+	if err != nil {
+		panic(err)
+	}
+	table.AddIndex("diff_test_sku_name_idx", "Btree", []string{"Sku", "Name"})
+	dbmap.AddTableWithName(Invoice{}, "diff_missing_test").SetKeys(true, "Id")
+
+	diffs, err = dbmap.DiffSchema()
+	if err != nil {
+		panic(err)
+	}
+	kinds := make([]SchemaDifferenceKind, len(diffs))
+	for i, d := range diffs {
+		kinds[i] = d.Kind
+		if d.Sql == "" {
+			t.Errorf("no statement generated for %v", d)
+		}
+	}
+	want := []SchemaDifferenceKind{DiffExtraColumn, DiffMissingIndex, DiffMissingTable}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("got differences %v, want %v", diffs, want)
+	}
+	if len(diffs) == 3 && !strings.EqualFold(diffs[0].Name, "extra") {
+		t.Errorf("expected extra column, got %v", diffs[0])
+	}
+}
+
+func TestIntrospectTable(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(UpsertItem{}, "introspect_test").SetKeys(true, "Id").SetUniqueTogether("Sku", "Name")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	d := dbmap.Dialect
+	_, err = dbmap.Exec(fmt.Sprintf("create table %s (%s integer not null primary key, %s bigint default 7, "+
+		"foreign key (%s) references %s (%s) on delete cascade)",
+		d.QuoteField("introspect_child_test"), d.QuoteField("Id"), d.QuoteField("ParentId"),
+		d.QuoteField("ParentId"), d.QuoteField("introspect_test"), d.QuoteField("Id")))
+	if err != nil {
+		panic(err)
+	}
+	defer dbmap.Exec("drop table " + d.QuoteField("introspect_child_test"))
+
+	info, err := dbmap.IntrospectTable("", "introspect_test")
+	if err != nil {
+		panic(err)
+	}
+	if info == nil || len(info.Columns) != 4 || !strings.EqualFold(info.Columns[1].Name, "Sku") {
+		t.Fatalf("unexpected columns %+v", info)
+	}
+	if info.Columns[0].Nullable || !info.Columns[2].Nullable {
+		t.Errorf("unexpected nullability %+v", info.Columns)
+	}
+	if len(info.PrimaryKey) != 1 || !strings.EqualFold(info.PrimaryKey[0], "Id") {
+		t.Errorf("unexpected primary key %v", info.PrimaryKey)
+	}
+	if len(info.UniqueConstraints) != 1 || len(info.UniqueConstraints[0].Columns) != 2 ||
+		!strings.EqualFold(info.UniqueConstraints[0].Columns[1], "Name") {
+		t.Errorf("unexpected unique constraints %+v", info.UniqueConstraints)
+	}
+	if len(info.Indexes) != 1 || !info.Indexes[0].Unique {
+		t.Errorf("unexpected indexes %+v", info.Indexes)
+	}
+
+	child, err := dbmap.IntrospectTable("", "introspect_child_test")
+	if err != nil {
+		panic(err)
+	}
+	if child == nil || !child.Columns[1].Default.Valid || !strings.Contains(child.Columns[1].Default.String, "7") {
+		t.Errorf("unexpected default in %+v", child)
+	} else if len(child.ForeignKeys) != 1 {
+		t.Errorf("unexpected foreign keys %+v", child.ForeignKeys)
+	} else {
+		fk := child.ForeignKeys[0]
+		if !strings.EqualFold(fk.RefTable, "introspect_test") || !strings.EqualFold(fk.Columns[0], "ParentId") ||
+			!strings.EqualFold(fk.RefColumns[0], "Id") || fk.OnDelete != "CASCADE" || fk.OnUpdate != "NO ACTION" {
+			t.Errorf("unexpected foreign key %+v", fk)
+		}
+	}
+
+	missing, err := dbmap.IntrospectTable("", "introspect_missing_test")
+	if err != nil || missing != nil {
+		t.Errorf("expected no table, got %+v, %v", missing, err)
+	}
+
+	tables, err := dbmap.IntrospectSchema("")
+	if err != nil {
+		panic(err)
+	}
+	found := 0
+	for _, table := range tables {
+		if strings.HasPrefix(strings.ToLower(table.Name), "introspect_") {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("expected 2 introspect tables, found %d", found)
+	}
+}
+
+func TestSelectIter(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	for _, name := range []string{"a", "b", "c"} {
+		_insert(dbmap, &Person{0, 0, 0, name, "smith", 0})
+	}
+
+	iter, err := dbmap.SelectIter(Person{}, "select * from person_test order by id")
+	if err != nil {
+		panic(err)
+	}
+	defer iter.Close()
+	var names []string
+	for iter.Next() {
+		var p Person
+		err = iter.Scan(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.LName != "postget" {
+			t.Errorf("PostGet() didn't run: %v", p)
+		}
+		names = append(names, p.FName)
+	}
+	if err = iter.Err(); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Errorf("got names %v", names)
+	}
+	iter.Close()
+
+	iter, err = dbmap.SelectIter(int64(0), "select id from person_test order by id")
+	if err != nil {
+		panic(err)
+	}
+	defer iter.Close()
+	count := 0
+	for iter.Next() {
+		var id int64
+		if err = iter.Scan(&id); err != nil || id == 0 {
+			t.Errorf("scanned id %d, err %v", id, err)
+		}
+		var p Person
+		if err = iter.Scan(&p); err == nil {
+			t.Errorf("expected error scanning into wrong type")
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("iterated over %d ids, want 3", count)
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{0, 0, 0, "alice", "smith", 0}
+	p2 := &Person{0, 0, 0, "bob", "jones", 0}
+	_insert(dbmap, p1, p2)
+	for i, memo := range []string{"a1", "a2", "b1", "a3"} {
+		inv := &Invoice{0, int64(i), 0, memo, p1.Id, false}
+		if memo[0] == 'b' {
+			inv.PersonId = p2.Id
+		}
+		_insert(dbmap, inv)
+	}
+
+	var invoices []*Invoice
+	_, err := dbmap.Query(Invoice{}).
+		Where("PersonId = ?", p1.Id).
+		And("Memo <> ?", "a2").
+		OrderBy("Created desc").
+		Select(&invoices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 || invoices[0].Memo != "a3" || invoices[1].Memo != "a1" {
+		t.Errorf("unexpected invoices %v", invoices)
+	}
+
+	list, err := dbmap.Query(Invoice{}).
+		Where("Memo = ?", "b1").
+		Or("Id in (?)", []int64{invoices[0].Id, invoices[1].Id}).
+		OrderBy("Id").
+		Limit(2).
+		Offset(1).
+		Select(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].(*Invoice).Memo != "b1" || list[1].(*Invoice).Memo != "a3" {
+		t.Errorf("unexpected invoices %v", list)
+	}
+
+	var view InvoicePersonView
+	err = dbmap.Query(Invoice{}).
+		Columns("invoice_test.Id as InvoiceId", "PersonId", "Memo", "Person.FName").
+		Join(Person{}, "Person.Id = PersonId").
+		Where("Person.FName = ?", "bob").
+		SelectOne(&view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Memo != "b1" || view.FName != "bob" || view.PersonId != p2.Id {
+		t.Errorf("unexpected join result %v", view)
+	}
+
+	var counts []struct {
+		PersonId int64
+		Total    int64
+	}
+	_, err = dbmap.Query(Invoice{}).
+		Columns("PersonId", "count(*) as Total").
+		GroupBy("PersonId").
+		Having("count(*) > ?", 1).
+		Select(&counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0].PersonId != p1.Id || counts[0].Total != 3 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	_, err = dbmap.Query(Invoice{}).Join(WithStringPk{}, "1 = 1").Select(nil)
+	if err == nil {
+		t.Errorf("expected error joining an unmapped type")
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	dbmap := newDbMap()
+	// registered before the table it references
+	invoices := dbmap.AddTableWithName(Invoice{}, "fk_invoice_test").SetKeys(true, "Id")
+	invoices.ColMap("PersonId").SetForeignKey("fk_person_test", "Id").SetOnDelete("cascade")
+	dbmap.AddTableWithName(Person{}, "fk_person_test").SetKeys(true, "Id")
+	tags := dbmap.AddTableWithName(InvoiceTag{}, "fk_invoice_tag_test")
+	tags.AddForeignKey([]string{"PersonId"}, "fk_person_test", []string{"Id"})
+
+	if sql := invoices.SqlForCreate(false); !strings.Contains(sql, "foreign key") {
+		t.Errorf("expected a foreign key in %s", sql)
+	}
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		t.Fatalf("create tables in dependency order: %v", err)
+	}
+
+	p := &Person{0, 0, 0, "alice", "smith", 0}
+	_insert(dbmap, p)
+	_insert(dbmap, &Invoice{0, 0, 0, "a1", p.Id, false})
+
+	if _, ok := dbmap.Dialect.(SchemaIntrospector); ok {
+		info, err := dbmap.IntrospectTable("", "fk_invoice_test")
+		if err != nil {
+			panic(err)
+		}
+		if len(info.ForeignKeys) != 1 || !strings.EqualFold(info.ForeignKeys[0].RefTable, "fk_person_test") ||
+			info.ForeignKeys[0].OnDelete != "CASCADE" {
+			t.Errorf("unexpected foreign keys %+v", info.ForeignKeys)
+		}
+	}
+
+	err = dbmap.DropTables()
+	if err != nil {
+		t.Errorf("drop tables in dependency order: %v", err)
+	}
+	dbmap.Db.Close()
+}
+
+func TestForeignKeyCycle(t *testing.T) {
+	dbmap := newDbMap()
+	departments := dbmap.AddTableWithName(Department{}, "fk_department_test").SetKeys(true, "Id")
+	departments.ColMap("ManagerId").SetForeignKey("fk_employee_test", "Id")
+	employees := dbmap.AddTableWithName(Employee{}, "fk_employee_test").SetKeys(true, "Id")
+	employees.ColMap("DepartmentId").SetForeignKey("fk_department_test", "Id")
+	employees.ColMap("BossId").SetForeignKey("fk_employee_test", "Id")
+
+	ordered, cycles := dependencyOrder(dbmap.tables)
+	if len(ordered) != 2 || ordered[0] != employees || ordered[1] != departments {
+		t.Errorf("unexpected order %v", ordered)
+	}
+	fks := employees.ForeignKeys()
+	if len(cycles) != 1 || !cycles[fks[0]] {
+		t.Errorf("expected the department foreign key to close the cycle, got %v", cycles)
+	}
+	if sql := strings.ToLower(employees.sqlForCreate(false, cycles)); strings.Contains(sql, "fk_department_test") ||
+		!strings.Contains(sql, "fk_fk_employee_test_bossid") {
+		t.Errorf("expected only the self-reference in %s", sql)
+	}
+
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		t.Fatalf("create tables referencing each other: %v", err)
+	}
+	err = dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		t.Errorf("create existing tables referencing each other: %v", err)
+	}
+
+	d := &Department{Name: "sales"}
+	_insert(dbmap, d)
+	e := &Employee{Name: "alice", DepartmentId: d.Id}
+	_insert(dbmap, e)
+	d.ManagerId = sql.NullInt64{Int64: e.Id, Valid: true}
+	_update(dbmap, d)
+
+	if _, ok := dbmap.Dialect.(SchemaIntrospector); ok {
+		info, err := dbmap.IntrospectTable("", "fk_employee_test")
+		if err != nil {
+			panic(err)
+		}
+		if len(info.ForeignKeys) != 2 {
+			t.Errorf("unexpected foreign keys %+v", info.ForeignKeys)
+		}
+	}
+
+	err = dbmap.DropTables()
+	if err != nil {
+		t.Errorf("drop tables referencing each other: %v", err)
+	}
+	dbmap.Db.Close()
+}
+
+func TestPreload(t *testing.T) {
+	dbmap := newDbMap()
+	persons := dbmap.AddTableWithName(RelPerson{}, "rel_person_test").SetKeys(true, "Id")
+	invoices := dbmap.AddTableWithName(RelInvoice{}, "rel_invoice_test").SetKeys(true, "Id")
+	tags := dbmap.AddTableWithName(RelTag{}, "rel_tag_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(RelDetail{}, "rel_detail_test").SetKeys(true, "Id")
+	invoices.ColMap("PersonId").SetForeignKey("rel_person_test", "Id")
+	tags.ColMap("InvoiceId").SetForeignKey("rel_invoice_test", "Id")
+	persons.HasMany("Invoices")
+	invoices.BelongsTo("Person")
+	invoices.HasMany("Tags")
+	invoices.HasOne("Detail", "InvoiceId")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	alice := &RelPerson{Name: "alice"}
+	bob := &RelPerson{Name: "bob"}
+	_insert(dbmap, alice, bob)
+	i1 := &RelInvoice{PersonId: alice.Id, Memo: "i1"}
+	i2 := &RelInvoice{PersonId: alice.Id, Memo: "i2"}
+	i3 := &RelInvoice{PersonId: bob.Id, Memo: "i3"}
+	_insert(dbmap, i1, i2, i3)
+	_insert(dbmap, &RelTag{InvoiceId: i1.Id, Name: "t1"}, &RelTag{InvoiceId: i1.Id, Name: "t2"},
+		&RelTag{InvoiceId: i3.Id, Name: "t3"}, &RelDetail{InvoiceId: i2.Id, Text: "d2"})
+
+	list, err := dbmap.Select(RelInvoice{}, "select * from rel_invoice_test order by "+
+		dbmap.Dialect.QuoteField("Id"))
+	if err != nil {
+		panic(err)
+	}
+	logger := &countingLogger{}
+	dbmap.TraceOn("", logger)
+	err = dbmap.Preload(list, "Person", "Tags", "Detail")
+	dbmap.TraceOff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.count != 3 {
+		t.Errorf("preloading 3 relations ran %d queries", logger.count)
+	}
+	r1, r2, r3 := list[0].(*RelInvoice), list[1].(*RelInvoice), list[2].(*RelInvoice)
+	if r1.Person == nil || r1.Person.Name != "alice" || r3.Person == nil || r3.Person.Name != "bob" {
+		t.Errorf("belongs-to not loaded: %v %v", r1.Person, r3.Person)
+	}
+	if len(r1.Tags) != 2 || r2.Tags == nil || len(r2.Tags) != 0 || len(r3.Tags) != 1 || r3.Tags[0].Name != "t3" {
+		t.Errorf("has-many not loaded: %v %v %v", r1.Tags, r2.Tags, r3.Tags)
+	}
+	if r1.Detail != nil || r2.Detail == nil || r2.Detail.Text != "d2" {
+		t.Errorf("has-one not loaded: %v %v", r1.Detail, r2.Detail)
+	}
+
+	var people []RelPerson
+	_, err = dbmap.Query(RelPerson{}).OrderBy("Name").Preload("Invoices.Tags").Select(&people)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 2 || len(people[0].Invoices) != 2 || len(people[1].Invoices) != 1 ||
+		len(people[1].Invoices[0].Tags) != 1 {
+		t.Errorf("nested relations not loaded: %+v", people)
+	}
+
+	err = dbmap.Preload(list, "Nonexistent")
+	if err == nil {
+		t.Errorf("expected error preloading an undeclared relation")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	dbmap := newDbMap()
+	people := dbmap.AddTableWithName(SoftPerson{}, "soft_person_test").SetKeys(true, "Id")
+	people.SetVersionCol("Version")
+	people.SetSoftDeleteCol("DeletedAt")
+	dbmap.AddTableWithName(SoftFlag{}, "soft_flag_test").SetKeys(true, "Id").SetSoftDeleteCol("Deleted")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	p1 := &SoftPerson{Name: "p1"}
+	p2 := &SoftPerson{Name: "p2"}
+	f1 := &SoftFlag{Name: "f1"}
+	_insert(dbmap, p1, p2, f1)
+
+	// a stale version is still rejected
+	stale := *p1
+	stale.Version = 5
+	_, err = dbmap.Delete(&stale)
+	if _, ok := err.(OptimisticLockError); !ok {
+		t.Errorf("expected OptimisticLockError deleting a stale row, got %v", err)
+	}
+
+	count, err := dbmap.Delete(p1, f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || p1.DeletedAt == nil || p1.Version != 2 || !f1.Deleted {
+		t.Errorf("soft delete: count=%d deletedAt=%v version=%d deleted=%v", count, p1.DeletedAt, p1.Version, f1.Deleted)
+	}
+	total, err := dbmap.SelectInt("select count(*) from soft_person_test")
+	if err != nil {
+		panic(err)
+	}
+	if total != 2 {
+		t.Errorf("soft deleted row was removed: %d rows left", total)
+	}
+
+	if obj, err := dbmap.Get(SoftPerson{}, p1.Id); err != nil || obj != nil {
+		t.Errorf("Get returned soft deleted row: %v %v", obj, err)
+	}
+	if obj, err := dbmap.Get(SoftFlag{}, f1.Id); err != nil || obj != nil {
+		t.Errorf("Get returned soft deleted flag: %v %v", obj, err)
+	}
+	if obj, err := dbmap.WithDeleted().Get(SoftPerson{}, p1.Id); err != nil || obj == nil {
+		t.Errorf("WithDeleted().Get did not return soft deleted row: %v %v", obj, err)
+	}
+	var live []SoftPerson
+	_, err = dbmap.Query(SoftPerson{}).Select(&live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 1 || live[0].Name != "p2" {
+		t.Errorf("Query returned soft deleted rows: %+v", live)
+	}
+	all, err := dbmap.WithDeleted().Query(SoftPerson{}).Select(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("WithDeleted().Query returned %d rows", len(all))
+	}
+
+	count, err = dbmap.Delete(f1)
+	if err != nil || count != 0 {
+		t.Errorf("deleting a soft deleted row: count=%d err=%v", count, err)
+	}
+	_, err = dbmap.Delete(p1)
+	if ole, ok := err.(OptimisticLockError); !ok || ole.RowExists {
+		t.Errorf("expected OptimisticLockError deleting a soft deleted versioned row, got %v", err)
+	}
+
+	count, err = dbmap.Restore(p1, f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || p1.DeletedAt != nil || p1.Version != 3 || f1.Deleted {
+		t.Errorf("restore: count=%d deletedAt=%v version=%d deleted=%v", count, p1.DeletedAt, p1.Version, f1.Deleted)
+	}
+	if obj, err := dbmap.Get(SoftPerson{}, p1.Id); err != nil || obj == nil {
+		t.Errorf("Get did not return restored row: %v %v", obj, err)
+	}
+
+	count, err = dbmap.HardDelete(p2)
+	if err != nil || count != 1 {
+		t.Errorf("hard delete: count=%d err=%v", count, err)
+	}
+	total, err = dbmap.SelectInt("select count(*) from soft_person_test")
+	if err != nil {
+		panic(err)
+	}
+	if total != 1 {
+		t.Errorf("hard deleted row was kept: %d rows left", total)
+	}
+
+	_, err = dbmap.Restore(&Person{})
+	if err == nil {
+		t.Errorf("expected error restoring a row of a table without soft delete column")
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Stamped{}, "stamped_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dbmap.Clock = ClockFunc(func() time.Time { return now })
+
+	s := &Stamped{Name: "s"}
+	_insert(dbmap, s)
+	if !s.Created.Equal(now) || s.Updated == nil || !s.Updated.Equal(now) {
+		t.Errorf("insert timestamps: created=%v updated=%v", s.Created, s.Updated)
+	}
+
+	inserted := now
+	now = now.Add(time.Hour)
+	s.Created = time.Time{}
+	_, err = dbmap.Update(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Updated == nil || !s.Updated.Equal(now) {
+		t.Errorf("update did not set updated: %v", s.Updated)
+	}
+
+	obj, err := dbmap.Get(Stamped{}, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := obj.(*Stamped)
+	if !got.Created.Equal(inserted) {
+		t.Errorf("update overwrote created: %v", got.Created)
+	}
+	if got.Updated == nil || !got.Updated.Equal(now) {
+		t.Errorf("updated not stored: %v", got.Updated)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	var seen []*Statement
+	var results []*StatementResult
+	injected := errors.New("injected")
+	inject := false
+	dbmap.AddInterceptor(InterceptorFunc(func(stmt *Statement, next StatementHandler) *StatementResult {
+		if inject {
+			return &StatementResult{Err: injected}
+		}
+		res := next(stmt)
+		seen = append(seen, stmt)
+		results = append(results, res)
+		return res
+	}), InterceptorFunc(func(stmt *Statement, next StatementHandler) *StatementResult {
+		// rewrite a placeholder statement
+		if stmt.Query == "select 'rewrite me'" {
+			stmt.Query = "select 42"
+		}
+		return next(stmt)
+	}))
+	invoices, _ := dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+
+	inv := &Invoice{Memo: "intercepted"}
+	_insert(dbmap, inv)
+	if len(seen) != 1 || seen[0].Kind != ExecStatement || seen[0].Table != invoices ||
+		results[0].Err != nil || results[0].Result == nil {
+		t.Errorf("insert not intercepted: %+v", seen)
+	}
+
+	seen, results = nil, nil
+	_get(dbmap, Invoice{}, inv.Id)
+	if len(seen) != 1 || seen[0].Kind != QueryRowStatement || seen[0].Table != invoices || results[0].Row == nil {
+		t.Errorf("get not intercepted: %+v", seen)
+	}
+
+	seen, results = nil, nil
+	var list []Invoice
+	_, err := dbmap.Select(&list, "select * from invoice_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0].Kind != QueryStatement || seen[0].Table != invoices || results[0].Rows == nil {
+		t.Errorf("select not intercepted: %+v", seen)
+	}
+
+	n, err := dbmap.SelectInt("select 'rewrite me'")
+	if err != nil || n != 42 {
+		t.Errorf("rewritten statement returned %d, %v", n, err)
+	}
+	if seen[len(seen)-1].Table != nil {
+		t.Errorf("ad hoc statement reported table %v", seen[len(seen)-1].Table)
+	}
+
+	inject = true
+	if _, err = dbmap.Exec("delete from invoice_test"); err != injected {
+		t.Errorf("expected injected Exec error, got %v", err)
+	}
+	if _, err = dbmap.Get(Invoice{}, inv.Id); err != injected {
+		t.Errorf("expected injected QueryRow error, got %v", err)
+	}
+	if _, err = dbmap.Select(&list, "select * from invoice_test"); err != injected {
+		t.Errorf("expected injected Query error, got %v", err)
+	}
+	inject = false
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	seen = nil
+	_, err = trans.Delete(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0].Table != invoices {
+		t.Errorf("transaction statement not intercepted: %+v", seen)
+	}
+}
+
+func TestStructuredLogger(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	var buf bytes.Buffer
+	dbmap.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	records := func() []map[string]interface{} {
+		var list []map[string]interface{}
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var rec map[string]interface{}
+			if err := dec.Decode(&rec); err != nil {
+				panic(err)
+			}
+			list = append(list, rec)
+		}
+		buf.Reset()
+		return list
+	}
+
+	inv := &Invoice{Memo: "logged"}
+	_insert(dbmap, inv)
+	recs := records()
+	if len(recs) != 1 || recs[0]["level"] != "DEBUG" || recs[0]["operation"] != "insert" ||
+		recs[0]["table"] != "invoice_test" || recs[0]["rows"] != float64(1) || recs[0]["duration"] == nil {
+		t.Errorf("unexpected insert records %v", recs)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	_, err = trans.Exec("update invoice_test set "+dbmap.Dialect.QuoteField("Memo")+" = :memo where "+
+		dbmap.Dialect.QuoteField("Id")+" = :id", map[string]interface{}{"memo": "x", "id": inv.Id})
+	if err != nil {
+		panic(err)
+	}
+	trans.Rollback()
+	recs = records()
+	if len(recs) != 3 || recs[1]["tx"] == nil || recs[1]["tx"] != recs[2]["tx"] || recs[1]["operation"] != "exec" {
+		t.Errorf("unexpected transaction records %v", recs)
+	}
+
+	_, err = dbmap.Exec("select * from no_such_table")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	recs = records()
+	if len(recs) != 1 || recs[0]["level"] != "ERROR" || recs[0]["error"] == nil {
+		t.Errorf("unexpected error records %v", recs)
+	}
+
+	dbmap.SetSlowThreshold(time.Hour)
+	_get(dbmap, Invoice{}, inv.Id)
+	if recs = records(); len(recs) != 0 {
+		t.Errorf("fast statement logged above the slow threshold: %v", recs)
+	}
+}
+
+func TestSensitiveColumns(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Account{}, "account_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	var trace, structured bytes.Buffer
+	dbmap.TraceOn("", log.New(&trace, "", 0))
+	dbmap.SetLogger(slog.New(slog.NewJSONHandler(&structured, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	a := &Account{Login: "alice", Password: "hunter2"}
+	_insert(dbmap, a)
+	a.Password = "correct horse"
+	_update(dbmap, a)
+	got := _get(dbmap, Account{}, a.Id).(*Account)
+	if got.Password != "correct horse" {
+		t.Errorf("password not stored: %v", got)
+	}
+	logs := trace.String() + structured.String()
+	if strings.Contains(logs, "hunter2") || strings.Contains(logs, "correct horse") {
+		t.Errorf("sensitive value logged:\n%s", logs)
+	}
+	if !strings.Contains(trace.String(), "[redacted]") || !strings.Contains(structured.String(), "[redacted]") {
+		t.Errorf("sensitive value not masked:\n%s", logs)
+	}
+	if !strings.Contains(logs, "alice") {
+		t.Errorf("other values masked:\n%s", logs)
+	}
+
+	trace.Reset()
+	structured.Reset()
+	dbmap.SetRedactor(RedactorFunc(func(query string, args []interface{}) []interface{} {
+		if !strings.Contains(query, "Password") {
+			return args
+		}
+		masked := make([]interface{}, len(args))
+		for i := range masked {
+			masked[i] = Redacted{}
+		}
+		return masked
+	}))
+	_, err = dbmap.Exec("update account_test set "+dbmap.Dialect.QuoteField("Password")+" = :pw where "+
+		dbmap.Dialect.QuoteField("Id")+" = :id", map[string]interface{}{"pw": "s3cret", "id": a.Id})
+	if err != nil {
+		panic(err)
+	}
+	logs = trace.String() + structured.String()
+	if strings.Contains(logs, "s3cret") || !strings.Contains(logs, "[redacted]") {
+		t.Errorf("ad hoc query not redacted:\n%s", logs)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	metrics := NewMemoryMetrics()
+	dbmap.SetMetrics(metrics)
+
+	inv := &Invoice{Memo: "measured"}
+	_insert(dbmap, inv)
+	_get(dbmap, Invoice{}, inv.Id)
+	_get(dbmap, Invoice{}, inv.Id)
+	_, err := dbmap.Select(&Invoice{}, "select * from invoice_test")
+	if err != nil {
+		panic(err)
+	}
+	_, err = dbmap.Exec("select * from no_such_table")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	snap := metrics.Snapshot()
+	gets := snap[MetricsKey{Operation: "get", Table: "invoice_test"}]
+	if gets.Count != 2 || gets.Errors != 0 || gets.Sum <= 0 || gets.BucketCounts[len(gets.Buckets)-1] != 2 {
+		t.Errorf("unexpected get metrics %+v", gets)
+	}
+	for _, key := range []MetricsKey{{"insert", "invoice_test"}, {"select", "invoice_test"}} {
+		if snap[key].Count != 1 {
+			t.Errorf("unexpected %v metrics %+v", key, snap[key])
+		}
+	}
+	if execs := snap[MetricsKey{Operation: "exec"}]; execs.Count != 1 || execs.Errors != 1 {
+		t.Errorf("unexpected exec metrics %+v", execs)
+	}
+
+	metrics.Reset()
+	if snap := metrics.Snapshot(); len(snap) != 0 {
+		t.Errorf("metrics not reset: %v", snap)
+	}
+
+	metrics = NewMemoryMetrics(10*time.Millisecond, time.Millisecond)
+	for _, d := range []time.Duration{time.Millisecond, 5 * time.Millisecond, time.Second} {
+		metrics.Observe("exec", "", d, nil)
+	}
+	m := metrics.Snapshot()[MetricsKey{Operation: "exec"}]
+	if m.Count != 3 || !reflect.DeepEqual(m.Buckets, []time.Duration{time.Millisecond, 10 * time.Millisecond}) ||
+		!reflect.DeepEqual(m.BucketCounts, []int64{1, 2}) {
+		t.Errorf("unexpected histogram %+v", m)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	dbmap.SetStmtCache(2)
+	defer dbmap.SetStmtCache(0)
+
+	cached := func() []string {
+		var queries []string
+		for e := dbmap.stmtCache.lru.Front(); e != nil; e = e.Next() {
+			queries = append(queries, e.Value.(*cachedStmt).query)
+		}
+		return queries
+	}
+
+	inv := &Invoice{Memo: "cached"}
+	_insert(dbmap, inv)
+	_get(dbmap, Invoice{}, inv.Id)
+	_get(dbmap, Invoice{}, inv.Id)
+	table, _ := dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+	if q := cached(); len(q) != 2 || q[0] != table.bindGet(true).query || q[1] != table.insertBindPlan().query {
+		t.Errorf("unexpected cached statements %v", q)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	inv.Memo = "updated"
+	_, err = trans.Update(inv)
+	if err != nil {
+		panic(err)
+	}
+	obj, err := trans.Get(Invoice{}, inv.Id)
+	if err != nil || obj.(*Invoice).Memo != "updated" {
+		t.Errorf("get in transaction: %v, %v", obj, err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+	if q := cached(); len(q) != 2 || q[1] != table.updatePlan.load().query {
+		t.Errorf("insert not evicted: %v", q)
+	}
+
+	_, err = dbmap.Select(&Invoice{}, "select * from invoice_test")
+	if err != nil {
+		panic(err)
+	}
+	_del(dbmap, inv)
+	if q := cached(); len(q) != 2 || q[0] != table.deletePlan.load().query {
+		t.Errorf("unexpected cached statements %v", q)
+	}
+	if obj := _get(dbmap, Invoice{}, inv.Id); obj != nil {
+		t.Errorf("row not deleted: %v", obj)
+	}
+}
+
+func TestConcurrentPlans(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			inv := &Invoice{Memo: fmt.Sprintf("concurrent %d", i)}
+			err := dbmap.Insert(inv)
+			if err == nil {
+				inv.Memo += " updated"
+				_, err = dbmap.Update(inv)
+			}
+			if err == nil {
+				_, err = dbmap.Get(Invoice{}, inv.Id)
+			}
+			if err == nil {
+				_, err = dbmap.Delete(inv)
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestConcurrentForeignKeys(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	invoices := dbmap.AddTableWithName(Invoice{}, "invoice_test").SetKeys(true, "Id")
+	invoices.ColMap("PersonId").SetForeignKey("person_test", "Id")
+	dbmap.AddTableWithName(Person{}, "person_test").SetKeys(true, "Id")
+	dbmap.Freeze()
+
+	done := make(chan bool, 8)
+	for i := 0; i < cap(done); i++ {
+		go func() {
+			fks := invoices.ForeignKeys()
+			ordered, _ := dependencyOrder(dbmap.tables)
+			done <- len(fks) == 1 && fks[0].table == invoices && ordered[1] == invoices
+		}()
+	}
+	for i := 0; i < cap(done); i++ {
+		if !<-done {
+			t.Errorf("unexpected foreign keys or table order")
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	table, _ := dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+	dbmap.Freeze()
+	if !dbmap.Frozen() || table.insertPlan.load().query == "" || table.getAllPlan.load().query == "" ||
+		table.updatePlan.load().query == "" || table.deletePlan.load().query == "" {
+		t.Errorf("plans not generated by Freeze")
+	}
+
+	inv := &Invoice{Memo: "frozen"}
+	_insert(dbmap, inv)
+	if obj := _get(dbmap, Invoice{}, inv.Id); obj == nil || obj.(*Invoice).Memo != "frozen" {
+		t.Errorf("unexpected row %v", obj)
+	}
+
+	for name, change := range map[string]func(){
+		"AddTable":     func() { dbmap.AddTable(Person{}) },
+		"SetKeys":      func() { table.SetKeys(true, "Id") },
+		"AddIndex":     func() { table.AddIndex("memo_idx", "", []string{"Memo"}) },
+		"ResetSql":     func() { table.ResetSql() },
+		"SetTransient": func() { table.ColMap("Memo").SetTransient(true) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%s did not panic on a frozen DbMap", name)
+				}
+			}()
+			change()
+		}()
+	}
+	if table.ColMap("Memo").Transient {
+		t.Errorf("frozen column changed")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	// The replica is the primary itself, so that the test runs on every
+	// dialect; only the routing is checked.
+	picks := 0
+	dbmap.SetReplicas(BalancerFunc(func(replicas []*sql.DB) *sql.DB {
+		picks++
+		return replicas[0]
+	}), dbmap.Db)
+	expectPicks := func(what string, n int) {
+		if picks != n {
+			t.Errorf("%s: expected %d replica reads, got %d", what, n, picks)
+		}
+		picks = 0
+	}
+
+	p := &Person{FName: "read", LName: "replica"}
+	_insert(dbmap, p)
+	p.FName = "updated"
+	_update(dbmap, p)
+	expectPicks("writes", 0)
+
+	_get(dbmap, Person{}, p.Id)
+	expectPicks("Get", 1)
+	_, err := dbmap.Select(&Person{}, "select * from person_test")
+	if err != nil {
+		panic(err)
+	}
+	_, err = dbmap.SelectInt("select count(*) from person_test")
+	if err != nil {
+		panic(err)
+	}
+	expectPicks("Select and SelectInt", 2)
+
+	_, err = dbmap.Primary().Get(Person{}, p.Id)
+	if err != nil {
+		panic(err)
+	}
+	expectPicks("Primary", 0)
+
+	stale := *p
+	stale.Version--
+	_, err = dbmap.Update(&stale)
+	if _, ok := err.(OptimisticLockError); !ok {
+		t.Errorf("expected OptimisticLockError, got %v", err)
+	}
+	expectPicks("optimistic lock check", 0)
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	_, err = trans.Get(Person{}, p.Id)
+	if err != nil {
+		panic(err)
+	}
+	trans.Rollback()
+	expectPicks("transaction", 0)
+
+	dbmap.SetReplicas(nil)
+	_get(dbmap, Person{}, p.Id)
+	expectPicks("no replicas", 0)
+}
+
+func TestRetryClassifier(t *testing.T) {
+	tests := []struct {
+		dialect   Dialect
+		err       error
+		retryable bool
+	}{
+		{PostgresDialect{}, &sqlStateError{"40001"}, true},
+		{PostgresDialect{}, fmt.Errorf("wrapped: %w", &sqlStateError{"40P01"}), true},
+		{PostgresDialect{}, &sqlStateError{"23505"}, false},
+		{MySQLDialect{}, &numberError{1213}, true},
+		{MySQLDialect{}, &numberError{1205}, true},
+		{MySQLDialect{}, &numberError{1062}, false},
+		{SqlServerDialect{}, &numberError{1205}, true},
+		{SqliteDialect{}, codeError{5}, true},
+		{SqliteDialect{}, codeError{19}, false},
+		{OracleDialect{}, errors.New("ORA-08177: can't serialize access for this transaction"), true},
+		{SqliteDialect{}, errors.New("database is locked"), false},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		if got := dbmap.IsRetryable(test.err); got != test.retryable {
+			t.Errorf("%T.IsRetryable(%v) = %v", test.dialect, test.err, got)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		dialect                   Dialect
+		err                       error
+		kind                      error
+		constraint, table, column string
+	}{
+		{PostgresDialect{}, &pqError{Code: "23505", Constraint: "users_email_key", Table: "users"}, ErrUniqueViolation, "users_email_key", "users", ""},
+		{PostgresDialect{}, &sqlStateError{"23503"}, ErrForeignKeyViolation, "", "", ""},
+		{PostgresDialect{}, fmt.Errorf("wrapped: %w", &pqError{Code: "23502", Table: "users", Column: "name"}), ErrNotNullViolation, "", "users", "name"},
+		{PostgresDialect{}, &sqlStateError{"40001"}, ErrSerialization, "", "", ""},
+		{PostgresDialect{}, &sqlStateError{"08006"}, ErrConnection, "", "", ""},
+		{PostgresDialect{}, &sqlStateError{"42P01"}, nil, "", "", ""},
+		{MySQLDialect{}, &mysqlError{1062, "Duplicate entry 'a@b.c' for key 'users.email'"}, ErrUniqueViolation, "email", "users", ""},
+		{MySQLDialect{}, &mysqlError{1452, "Cannot add or update a child row: a foreign key constraint fails (`db`.`orders`, CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"}, ErrForeignKeyViolation, "fk_user", "orders", "user_id"},
+		{MySQLDialect{}, &mysqlError{1048, "Column 'name' cannot be null"}, ErrNotNullViolation, "", "", "name"},
+		{MySQLDialect{}, &mysqlError{3819, "Check constraint 'age_positive' is violated."}, ErrCheckViolation, "age_positive", "", ""},
+		{MySQLDialect{}, &mysqlError{1213, "Deadlock found"}, ErrDeadlock, "", "", ""},
+		{MySQLDialect{}, &mysqlError{1205, "Lock wait timeout exceeded"}, ErrTimeout, "", "", ""},
+		{SqlServerDialect{}, &numberError{2627}, ErrUniqueViolation, "", "", ""},
+		{SqlServerDialect{}, &numberError{1205}, ErrDeadlock, "", "", ""},
+		{SqliteDialect{}, sqliteError{19, 2067, "UNIQUE constraint failed: users.email, users.org"}, ErrUniqueViolation, "", "users", "email"},
+		{SqliteDialect{}, sqliteError{19, 1299, "NOT NULL constraint failed: users.name"}, ErrNotNullViolation, "", "users", "name"},
+		{SqliteDialect{}, sqliteError{19, 275, "CHECK constraint failed: age_positive"}, ErrCheckViolation, "age_positive", "", ""},
+		{SqliteDialect{}, sqliteError{19, 787, "FOREIGN KEY constraint failed"}, ErrForeignKeyViolation, "", "", ""},
+		{SqliteDialect{}, sqliteError{5, 517, "database is locked"}, ErrSerialization, "", "", ""},
+		{SqliteDialect{}, codeError{5}, ErrTimeout, "", "", ""},
+		{OracleDialect{}, errors.New("ORA-00001: unique constraint (APP.USERS_EMAIL) violated"), ErrUniqueViolation, "USERS_EMAIL", "", ""},
+		{OracleDialect{}, errors.New(`ORA-01400: cannot insert NULL into ("APP"."USERS"."NAME")`), ErrNotNullViolation, "", "USERS", "NAME"},
+		{OracleDialect{}, errors.New("ORA-00060: deadlock detected while waiting for resource"), ErrDeadlock, "", "", ""},
+		{SqliteDialect{}, context.DeadlineExceeded, ErrTimeout, "", "", ""},
+		{SqliteDialect{}, fmt.Errorf("wrapped: %w", driver.ErrBadConn), ErrConnection, "", "", ""},
+	}
+	for _, test := range tests {
+		dbmap := &DbMap{Dialect: test.dialect}
+		err := dbmap.classifyError(test.err)
+		var dbErr *DbError
+		if test.kind == nil {
+			if err != test.err {
+				t.Errorf("%T: %v classified as %v", test.dialect, test.err, err)
+			}
+			continue
+		}
+		if !errors.As(err, &dbErr) || !errors.Is(err, test.kind) {
+			t.Errorf("%T: %v is not %v", test.dialect, test.err, test.kind)
+			continue
+		}
+		if !errors.Is(err, test.err) || err.Error() != test.err.Error() {
+			t.Errorf("%T: %v does not wrap %v", test.dialect, err, test.err)
+		}
+		if dbErr.Constraint != test.constraint || dbErr.Table != test.table || dbErr.Column != test.column {
+			t.Errorf("%T: %v names %q %q %q, want %q %q %q", test.dialect, test.err,
+				dbErr.Constraint, dbErr.Table, dbErr.Column, test.constraint, test.table, test.column)
+		}
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	dbmap := newDbMap()
+	table := dbmap.AddTableWithName(Member{}, "member_test").SetKeys(true, "Id")
+	table.ColMap("Email").SetUnique(true).SetMaxSize(100)
+	table.ColMap("Name").SetNotNull(true)
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	name := "Alice"
+	_insert(dbmap, &Member{Email: "alice@example.com", Name: &name})
+
+	err = dbmap.Insert(&Member{Email: "alice@example.com", Name: &name})
+	var dbErr *DbError
+	if !errors.Is(err, ErrUniqueViolation) || !errors.As(err, &dbErr) {
+		t.Fatalf("duplicate insert: %v", err)
+	}
+	if dbErr.Err == nil || errors.Unwrap(err) != dbErr.Err {
+		t.Errorf("driver error not wrapped: %#v", dbErr)
+	}
+	if _, ok := dbmap.Dialect.(SqliteDialect); ok && (dbErr.Table != "member_test" || dbErr.Column != "Email") {
+		t.Errorf("names = %q %q", dbErr.Table, dbErr.Column)
+	}
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer trans.Rollback()
+	err = trans.Insert(&Member{Email: "bob@example.com"})
+	if !errors.Is(err, ErrNotNullViolation) {
+		t.Errorf("null insert: %v", err)
+	}
+}
+
+func TestSelectIterErrorClass(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	for i := 0; i < 3; i++ {
+		_insert(dbmap, &Person{0, 0, 0, "alice", "smith", 0})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	iter, err := dbmap.WithContext(ctx).SelectIter(Person{}, "select * from person_test")
+	if err != nil {
+		panic(err)
+	}
+	defer iter.Close()
+	// let the rows be closed by the expired context
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	for iter.Next() {
+	}
+	if err := iter.Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestInTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	count := func() int64 {
+		n, err := dbmap.SelectInt("select count(*) from invoice_test")
+		if err != nil {
+			panic(err)
+		}
+		return n
+	}
+
+	err := dbmap.InTransaction(func(tx *Transaction) error {
+		return tx.Insert(&Invoice{Memo: "committed"})
+	}, nil)
+	if err != nil || count() != 1 {
+		t.Errorf("transaction not committed: %v", err)
+	}
+
+	failure := errors.New("failure")
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		tx.Insert(&Invoice{Memo: "rolled back"})
+		return failure
+	}, nil)
+	if err != failure || count() != 1 {
+		t.Errorf("transaction not rolled back: %v", err)
+	}
+
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		tx.Insert(&Invoice{Memo: "panicked"})
+		panic(failure)
+	}, nil)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || !errors.Is(err, failure) || len(panicErr.Stack) == 0 || count() != 1 {
+		t.Errorf("panic not recovered: %v", err)
+	}
+
+	conflict := errors.New("conflict")
+	opts := &TxOptions{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return 0 },
+		Retryable:   func(err error) bool { return err == conflict },
+	}
+	attempts := 0
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		attempts++
+		tx.Insert(&Invoice{Memo: fmt.Sprintf("attempt %d", attempts)})
+		if attempts < 3 {
+			return conflict
+		}
+		return nil
+	}, opts)
+	if err != nil || attempts != 3 || count() != 2 {
+		t.Errorf("expected a commit on the third attempt: %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		attempts++
+		return conflict
+	}, opts)
+	if err != conflict || attempts != 3 {
+		t.Errorf("expected %v after 3 attempts, got %v after %d", conflict, err, attempts)
+	}
+
+	attempts = 0
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		attempts++
+		return failure
+	}, opts)
+	if err != failure || attempts != 1 {
+		t.Errorf("non retryable error retried: %v after %d attempts", err, attempts)
+	}
+}
+
+func TestTxOptionsStatements(t *testing.T) {
+	tests := []struct {
+		dialect      TxOptionsDialect
+		isolation    sql.IsolationLevel
+		readOnly     bool
+		setup, reset []string
+	}{
+		{MySQLDialect{}, sql.LevelSerializable, false, []string{"set transaction isolation level serializable"}, nil},
+		{MySQLDialect{}, sql.LevelRepeatableRead, true, []string{"set transaction isolation level repeatable read, read only"}, nil},
+		{MySQLDialect{}, sql.LevelDefault, true, []string{"set transaction read only"}, nil},
+		{SqlServerDialect{}, sql.LevelSnapshot, false, []string{"set transaction isolation level snapshot;"},
+			[]string{"set transaction isolation level read committed;"}},
+		{SqliteDialect{}, sql.LevelSerializable, false, nil, nil},
+		{SqliteDialect{}, sql.LevelDefault, true, []string{"pragma query_only = 1;"}, []string{"pragma query_only = 0;"}},
+	}
+	for _, test := range tests {
+		setup, reset, err := test.dialect.TxOptionsStatements(test.isolation, test.readOnly)
+		if err != nil || !reflect.DeepEqual(setup, test.setup) || !reflect.DeepEqual(reset, test.reset) {
+			t.Errorf("%T %v read only %v: got %q, %q, %v", test.dialect, test.isolation, test.readOnly, setup, reset, err)
+		}
+	}
+
+	for _, test := range []struct {
+		dialect   TxOptionsDialect
+		isolation sql.IsolationLevel
+		readOnly  bool
+	}{
+		{MySQLDialect{}, sql.LevelSnapshot, false},
+		{SqlServerDialect{}, sql.LevelDefault, true},
+		{SqliteDialect{}, sql.LevelLinearizable, false},
+	} {
+		if _, _, err := test.dialect.TxOptionsStatements(test.isolation, test.readOnly); err == nil {
+			t.Errorf("%T %v read only %v: expected an error", test.dialect, test.isolation, test.readOnly)
+		}
+	}
+
+	var d TxBeginner = SqliteDialect{}
+	if q := d.BeginStatement(sql.LevelSerializable, false); q != "begin immediate;" {
+		t.Errorf("sqlite serializable: got %q", q)
+	}
+	if q := d.BeginStatement(sql.LevelSerializable, true); q != "" {
+		t.Errorf("sqlite read only: got %q", q)
+	}
+	if q := d.BeginStatement(sql.LevelDefault, false); q != "" {
+		t.Errorf("sqlite default isolation: got %q", q)
+	}
+}
+
+func TestSqliteImmediateTransaction(t *testing.T) {
+	if os.Getenv("GORP_TEST_DIALECT") != "sqlite" {
+		t.Skip("begin immediate is specific to sqlite")
+	}
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+
+	first, err := dbmap.BeginTx(context.Background(), opts)
+	if err != nil {
+		panic(err)
+	}
+	begun := make(chan error, 1)
+	go func() {
+		second, err := dbmap.BeginTx(context.Background(), opts)
+		if err == nil {
+			err = second.Insert(&Invoice{Memo: "second"})
+			if err == nil {
+				err = second.Commit()
+			} else {
+				second.Rollback()
+			}
+		}
+		begun <- err
+	}()
+	select {
+	case err = <-begun:
+		t.Fatalf("second writer not blocked at begin: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = first.Insert(&Invoice{Memo: "first"}); err != nil {
+		t.Errorf("insert in first transaction: %v", err)
+	}
+	if err = first.Commit(); err != nil {
+		t.Errorf("commit first transaction: %v", err)
+	}
+	if err = <-begun; err != nil {
+		t.Errorf("second transaction: %v", err)
+	}
+	if n, _ := dbmap.SelectInt("select count(*) from invoice_test"); n != 2 {
+		t.Errorf("expected 2 rows, got %d", n)
+	}
+}
+
+func TestReadOnlyTransaction(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+	// A single connection, to check that the settings of the read-only
+	// transaction do not outlive it.
+	dbmap.Db.SetMaxOpenConns(1)
+	seen := map[string]uint64{}
+	dbmap.AddInterceptor(InterceptorFunc(func(stmt *Statement, next StatementHandler) *StatementResult {
+		seen[stmt.Query] = stmt.TxID
+		return next(stmt)
+	}))
+
+	trans, err := dbmap.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		panic(err)
+	}
+	_, err = trans.SelectInt("select count(*) from invoice_test")
+	if err != nil {
+		t.Errorf("read in read-only transaction: %v", err)
+	}
+	if err = trans.Insert(&Invoice{Memo: "read only"}); err == nil {
+		t.Errorf("expected insert in read-only transaction to fail")
+	}
+	trans.Rollback()
+	if d, ok := dbmap.Dialect.(TxOptionsDialect); ok {
+		setup, reset, _ := d.TxOptionsStatements(sql.LevelDefault, true)
+		for _, query := range append(setup, reset...) {
+			if id, ok := seen[query]; !ok || id != trans.id {
+				t.Errorf("statement %q not intercepted with the transaction id", query)
+			}
+		}
+	}
+
+	err = dbmap.InTransaction(func(tx *Transaction) error {
+		return tx.Insert(&Invoice{Memo: "serializable"})
+	}, &TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Errorf("serializable transaction: %v", err)
+	}
+	if n, _ := dbmap.SelectInt("select count(*) from invoice_test"); n != 1 {
+		t.Errorf("expected 1 row, got %d", n)
+	}
+}
+
+func TestWithStringPk(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(WithStringPk{}, "string_pk_test").SetKeys(true, "Id")
+	_, err := dbmap.Exec("create table string_pk_test (Id varchar(255), Name varchar(255));")
+	if err != nil {
+		t.Errorf("couldn't create string_pk_test: %v", err)
+	}
+	defer dropAndClose(dbmap)
+
+	row := &WithStringPk{"1", "foo"}
+	err = dbmap.Insert(row)
+	if err == nil {
+		t.Errorf("Expected error when inserting into table w/non Int PK and autoincr set true")
+	}
+}
+
+// TestSqlExecutorInterfaceSelects ensures that all DbMap methods starting with Select...
+// are also exposed in the SqlExecutor interface. Select...  functions can always
+// run on Pre/Post hooks.
+func TestSqlExecutorInterfaceSelects(t *testing.T) {
+	dbMapType := reflect.TypeOf(&DbMap{})
+	sqlExecutorType := reflect.TypeOf((*SqlExecutor)(nil)).Elem()
+	numDbMapMethods := dbMapType.NumMethod()
+	for i := 0; i < numDbMapMethods; i += 1 {
+		dbMapMethod := dbMapType.Method(i)
+		if !strings.HasPrefix(dbMapMethod.Name, "Select") {
+			continue
+		}
+		if _, found := sqlExecutorType.MethodByName(dbMapMethod.Name); !found {
+			t.Errorf("Method %s is defined on DbMap but not implemented in SqlExecutor",
+				dbMapMethod.Name)
+		}
+	}
+}
+
+func TestNullTime(t *testing.T) {
+	dbmap := initDbMap()
+	defer dropAndClose(dbmap)
+
+	// if time is null
+	ent := &WithNullTime{
+		Id: 0,
+		Time: NullTime{
+			Valid: false,
+		}}
+	err := dbmap.Insert(ent)
+	if err != nil {
+		t.Errorf("failed insert on %s", err.Error())
+	}
+	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
+		"Id": ent.Id,
+	})
+	if err != nil {
+		t.Errorf("failed select on %s", err.Error())
+	}
+	if ent.Time.Valid {
+		t.Error("NullTime returns valid but expected null.")
+	}
+
+	// if time is not null
+	ts, err := time.Parse(time.Stamp, "Jan 2 15:04:05")
 	ent = &WithNullTime{
 		Id: 1,
 		Time: NullTime{
@@ -1582,13 +3553,13 @@ func TestNullTime(t *testing.T) {
 		}}
 	err = dbmap.Insert(ent)
 	if err != nil {
-		t.Error("failed insert on %s", err.Error())
+		t.Errorf("failed insert on %s", err.Error())
 	}
 	err = dbmap.SelectOne(ent, `select * from nulltime_test where Id=:Id`, map[string]interface{}{
 		"Id": ent.Id,
 	})
 	if err != nil {
-		t.Error("failed select on %s", err.Error())
+		t.Errorf("failed select on %s", err.Error())
 	}
 	if !ent.Time.Valid {
 		t.Error("NullTime returns invalid but expected valid.")
@@ -2093,6 +4064,21 @@ func BenchmarkGorpCrud(b *testing.B) {
 	defer dropAndClose(dbmap)
 	b.StartTimer()
 
+	benchmarkGorpCrud(b, dbmap)
+}
+
+func BenchmarkGorpCrudStmtCache(b *testing.B) {
+	b.StopTimer()
+	dbmap := initDbMapBench()
+	defer dropAndClose(dbmap)
+	dbmap.SetStmtCache(16)
+	defer dbmap.SetStmtCache(0)
+	b.StartTimer()
+
+	benchmarkGorpCrud(b, dbmap)
+}
+
+func benchmarkGorpCrud(b *testing.B, dbmap *DbMap) {
 	inv := &Invoice{0, 100, 200, "my memo", 0, true}
 	for i := 0; i < b.N; i++ {
 		err := dbmap.Insert(inv)
@@ -2325,3 +4311,242 @@ func _rawselect(dbmap *DbMap, i interface{}, query string, args ...interface{})
 	}
 	return list
 }
+
+func TestTransactionCallbacks(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Notice{}, "notice_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	var events []string
+	check := func(want ...string) {
+		if strings.Join(events, ", ") != strings.Join(want, ", ") {
+			t.Errorf("events = %q, want %q", events, want)
+		}
+		events = nil
+	}
+	notice := func(msg string) *Notice {
+		return &Notice{Msg: msg, Events: &events}
+	}
+
+	_insert(dbmap, notice("autocommit"))
+	check("commit autocommit")
+
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	if err := trans.Insert(notice("a")); err != nil {
+		panic(err)
+	}
+	child, err := trans.Begin()
+	if err != nil {
+		panic(err)
+	}
+	if err := child.Insert(notice("b")); err != nil {
+		panic(err)
+	}
+	if err := child.Rollback(); err != nil {
+		panic(err)
+	}
+	check("rollback b")
+	child, err = trans.Begin()
+	if err != nil {
+		panic(err)
+	}
+	if err := child.WithContext(context.Background()).Insert(notice("c")); err != nil {
+		panic(err)
+	}
+	if err := child.Commit(); err != nil {
+		panic(err)
+	}
+	check()
+	if err := trans.Commit(); err != nil {
+		panic(err)
+	}
+	check("commit a", "commit c")
+
+	err = dbmap.InTransaction(func(trans *Transaction) error {
+		if err := trans.Insert(notice("d")); err != nil {
+			panic(err)
+		}
+		return errors.New("abort")
+	}, &TxOptions{MaxAttempts: 1})
+	if err == nil {
+		t.Errorf("InTransaction did not fail")
+	}
+	check("rollback d")
+	if n, err := dbmap.SelectInt("select count(*) from notice_test"); err != nil || n != 3 {
+		t.Errorf("count = %d, %v, want 3", n, err)
+	}
+}
+
+func TestDbMapOnCommit(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Outbox{}, "outbox_test").SetKeys(false, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	var events []string
+	check := func(want ...string) {
+		if strings.Join(events, ", ") != strings.Join(want, ", ") {
+			t.Errorf("events = %q, want %q", events, want)
+		}
+		events = nil
+	}
+	outbox := func(id int64, msg string) *Outbox {
+		return &Outbox{Id: id, Msg: msg, Events: &events}
+	}
+
+	_insert(dbmap, outbox(1, "a"))
+	check("commit a")
+
+	// The second row has the key of the first, so the insert fails after
+	// both PreInsert hooks have run.
+	if err := dbmap.Insert(outbox(2, "b"), outbox(1, "c")); err == nil {
+		t.Errorf("duplicate insert did not fail")
+	}
+	check("rollback b", "rollback c")
+
+	if err := dbmap.WithContext(context.Background()).Insert(outbox(3, "d")); err != nil {
+		panic(err)
+	}
+	check("commit d")
+
+	dbmap.OnCommit(func() { events = append(events, "at once") })
+	check("at once")
+}
+
+func TestTagOptions(t *testing.T) {
+	dbmap := &DbMap{Dialect: SqliteDialect{}}
+	table := dbmap.AddTableWithName(Gadget{}, "gadget_test").SetKeys(true, "Id")
+
+	sku := table.ColMap("Sku")
+	if sku.SqlType != "varchar(20)" || !sku.isNotNull {
+		t.Errorf("sku = %+v", sku)
+	}
+	if qty := table.ColMap("Qty"); qty.Check != "qty >= 0" || qty.Comment != "units in stock" {
+		t.Errorf("qty = %+v", qty)
+	}
+	if price := table.ColMap("Price"); price.SqlType != "decimal(10,2)" || !price.Unique {
+		t.Errorf("price = %+v", price)
+	}
+	if !table.ColMap("Note").ReadOnly || !table.ColMap("Cache").Transient {
+		t.Errorf("note and cache not read-only and transient")
+	}
+	if idx := table.IdxMap("gadget_test_sku_idx"); idx == nil || !idx.Unique || !reflect.DeepEqual(idx.columns, []string{"sku"}) {
+		t.Errorf("sku index = %+v", idx)
+	}
+	if idx := table.IdxMap("gadget_place"); idx == nil || idx.Unique || !reflect.DeepEqual(idx.columns, []string{"shelf", "bin"}) {
+		t.Errorf("place index = %+v", idx)
+	}
+	if idx := table.IdxMap("gadget_maker_model"); idx == nil || !idx.Unique || !reflect.DeepEqual(idx.columns, []string{"maker", "model"}) {
+		t.Errorf("maker and model index = %+v", idx)
+	}
+
+	create := table.SqlForCreate(false)
+	for _, want := range []string{
+		`"sku" varchar(20) not null,`,
+		`"qty" integer check (qty >= 0),`,
+		`"price" decimal(10,2) unique,`,
+	} {
+		if !strings.Contains(create, want) {
+			t.Errorf("%s does not contain %s", create, want)
+		}
+	}
+	if strings.Contains(create, "cache") {
+		t.Errorf("transient column created: %s", create)
+	}
+	if insert := table.insertBindPlan().query; strings.Contains(insert, "note") {
+		t.Errorf("read-only column inserted: %s", insert)
+	}
+	if update := table.updateBindPlan().query; strings.Contains(update, "note") {
+		t.Errorf("read-only column updated: %s", update)
+	}
+
+	mysql := &DbMap{Dialect: MySQLDialect{}}
+	col := mysql.AddTableWithName(Gadget{}, "gadget_test").ColMap("Qty")
+	if def := col.table.sqlForColumn(col); !strings.HasSuffix(def, " comment 'units in stock'") {
+		t.Errorf("MySQL column = %s", def)
+	}
+	pg := &DbMap{Dialect: PostgresDialect{}}
+	comments := pg.AddTableWithName(Gadget{}, "gadget_test").sqlForComments()
+	if want := []string{`comment on column "gadget_test"."qty" is 'units in stock';`}; !reflect.DeepEqual(comments, want) {
+		t.Errorf("PostgreSQL comments = %q", comments)
+	}
+
+	for _, tag := range []string{"type", "comment", "notnull:yes", "readonly:1", "unique:maker_model"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("tag %q did not panic", tag)
+				}
+			}()
+			(&DbMap{Dialect: SqliteDialect{}}).AddTable(reflect.New(reflect.StructOf([]reflect.StructField{{
+				Name: "Id", Type: reflect.TypeOf(int64(0)), Tag: reflect.StructTag(`db:"id,` + tag + `"`),
+			}})).Elem().Interface())
+		}()
+	}
+}
+
+func TestTagSchema(t *testing.T) {
+	dbmap := newDbMap()
+	dbmap.AddTableWithName(Gadget{}, "gadget_test").SetKeys(true, "Id")
+	err := dbmap.DropTablesIfExists()
+	if err != nil {
+		panic(err)
+	}
+	err = dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	err = dbmap.CreateIndex()
+	if err != nil {
+		panic(err)
+	}
+
+	g := &Gadget{Sku: "A-1", Shelf: "s1", Bin: "b1", Maker: "acme", Model: "rocket", Qty: 3, Price: 1.5}
+	_insert(dbmap, g)
+	_, err = dbmap.Exec("update gadget_test set note = 'fragile' where id = " + strconv.FormatInt(g.Id, 10))
+	if err != nil {
+		panic(err)
+	}
+	note := "overwritten"
+	g.Note = &note
+	_update(dbmap, g)
+	got := _get(dbmap, Gadget{}, g.Id).(*Gadget)
+	if got.Note == nil || *got.Note != "fragile" {
+		t.Errorf("read-only note = %v", got.Note)
+	}
+
+	err = dbmap.Insert(&Gadget{Sku: "A-1", Maker: "acme", Model: "sled", Price: 2})
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("duplicate sku: %v", err)
+	}
+	err = dbmap.Insert(&Gadget{Sku: "A-2", Maker: "acme", Model: "rocket", Price: 3})
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("duplicate maker and model: %v", err)
+	}
+	switch dbmap.Dialect.(type) {
+	case SqliteDialect, PostgresDialect:
+		err = dbmap.Insert(&Gadget{Sku: "A-3", Maker: "acme", Model: "skates", Qty: -1, Price: 4})
+		if !errors.Is(err, ErrCheckViolation) {
+			t.Errorf("negative qty: %v", err)
+		}
+	}
+}
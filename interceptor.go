@@ -0,0 +1,230 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+)
+
+// StatementKind tells how a statement is run.
+type StatementKind int
+
+const (
+	// ExecStatement is run with Exec; its result is a sql.Result.
+	ExecStatement StatementKind = iota
+
+	// QueryStatement is run with Query; its result is a *sql.Rows.
+	QueryStatement
+
+	// QueryRowStatement is run with QueryRow; its result is a *sql.Row.
+	QueryRowStatement
+)
+
+func (k StatementKind) String() string {
+	switch k {
+	case ExecStatement:
+		return "exec"
+	case QueryStatement:
+		return "query"
+	case QueryRowStatement:
+		return "queryrow"
+	}
+	return "unknown"
+}
+
+// Statement is a statement about to be run through a DbMap or a
+// Transaction, as seen by interceptors.  Interceptors may change Query
+// and Args before passing the statement on.
+type Statement struct {
+	Kind  StatementKind
+	Query string
+	Args  []interface{}
+
+	// Table the statement was generated for by Insert, Update, Delete,
+	// Get, Upsert and the like, or the table mapped to the type a query
+	// selects into.  nil for other statements.
+	Table *TableMap
+
+	// Operation that generated the statement: "insert", "update",
+	// "delete", "restore", "get", "upsert" or "select".  Empty for
+	// statements run with Exec and the SelectInt family.
+	Operation string
+
+	// Sensitive flags the arguments bound to columns marked with
+	// ColumnMap.SetSensitive by the generated statement.  It may be
+	// shorter than Args, and is nil for statements without such
+	// arguments.
+	Sensitive []bool
+
+	// TxID identifies the Transaction running the statement within the
+	// process.  It is 0 for statements run outside of transactions.
+	TxID uint64
+
+	// Context the statement is run with.
+	Context context.Context
+}
+
+// StatementResult is the outcome of a statement.  Only the field matching
+// the statement's kind is set, unless Err is set instead.
+//
+// The error of a QueryRowStatement is reported by its Row, so Err only
+// holds errors found before it is scanned; sql.ErrNoRows is never
+// reported here.
+type StatementResult struct {
+	Result sql.Result
+	Rows   *sql.Rows
+	Row    *sql.Row
+	Err    error
+}
+
+// StatementHandler runs a statement.
+type StatementHandler func(stmt *Statement) *StatementResult
+
+// Interceptor wraps every statement run through a DbMap and its
+// Transactions, including those generated by Insert, Update, Get and the
+// like and those run by dialects.  Intercept usually calls next and
+// inspects or changes the statement and its result.  It may return a
+// result of its own instead, such as an error to inject.
+//
+// Begin, Commit, Rollback and Prepare are not intercepted.
+type Interceptor interface {
+	Intercept(stmt *Statement, next StatementHandler) *StatementResult
+}
+
+// The InterceptorFunc type is an adapter to allow the use of ordinary
+// functions as an Interceptor.
+type InterceptorFunc func(stmt *Statement, next StatementHandler) *StatementResult
+
+// Intercept calls f(stmt, next).
+func (f InterceptorFunc) Intercept(stmt *Statement, next StatementHandler) *StatementResult {
+	return f(stmt, next)
+}
+
+// AddInterceptor appends interceptors to the chain every statement runs
+// through.  The first interceptor added is the outermost one, seeing the
+// statement first and its result last.  Add interceptors before using the
+// DbMap; the chain is not safe to change concurrently with statements.
+func (m *DbMap) AddInterceptor(interceptors ...Interceptor) {
+	m.interceptors = append(m.interceptors, interceptors...)
+}
+
+// row is a single row result, as returned by sql.DB.QueryRow.
+type row interface {
+	Scan(dest ...interface{}) error
+}
+
+// errRow is a row that failed before it could be scanned.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// runStatement runs a statement of the given kind with e, through the
+// interceptors of its DbMap.
+func runStatement(e SqlExecutor, kind StatementKind, query string, args []interface{}) *StatementResult {
+	var (
+		m        *DbMap
+		conn     executor
+		replicas bool // whether reads may go to the replicas of m
+		stmt     = &Statement{Kind: kind, Query: query, Args: args, Context: e.Context()}
+	)
+	switch ex := e.(type) {
+	case *DbMap:
+		m, conn, replicas = ex, ex.Db, !ex.onPrimary
+		stmt.Table, stmt.Operation, stmt.Sensitive = ex.stmtTable, ex.stmtOp, ex.stmtSensitive
+	case *Transaction:
+		m, conn = ex.dbmap, ex.txConn()
+		stmt.Table, stmt.Operation, stmt.TxID = ex.stmtTable, ex.stmtOp, ex.id
+		stmt.Sensitive = ex.stmtSensitive
+	}
+	return m.run(conn, replicas, stmt)
+}
+
+// run runs stmt on conn, or on a replica if replicas is true and the
+// balancer picks one, through the interceptors of m.
+func (m *DbMap) run(conn executor, replicas bool, stmt *Statement) *StatementResult {
+	var handler StatementHandler = func(stmt *Statement) *StatementResult {
+		conn := conn
+		if replicas {
+			if replica := m.replica(stmt); replica != nil {
+				conn = replica
+			}
+		}
+		conn, release, err := m.cachedExecutor(conn, stmt)
+		if err != nil {
+			return &StatementResult{Err: m.classifyError(err)}
+		}
+		defer release()
+		switch stmt.Kind {
+		case QueryStatement:
+			rows, err := conn.QueryContext(stmt.Context, stmt.Query, stmt.Args...)
+			return &StatementResult{Rows: rows, Err: m.classifyError(err)}
+		case QueryRowStatement:
+			row := conn.QueryRowContext(stmt.Context, stmt.Query, stmt.Args...)
+			return &StatementResult{Row: row, Err: m.classifyError(row.Err())}
+		}
+		res, err := conn.ExecContext(stmt.Context, stmt.Query, stmt.Args...)
+		return &StatementResult{Result: res, Err: m.classifyError(err)}
+	}
+	if m.structLogger != nil {
+		handler = m.logStatements(handler)
+	}
+	if m.metrics != nil {
+		handler = m.observeStatements(handler)
+	}
+	for i := len(m.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := m.interceptors[i], handler
+		handler = func(stmt *Statement) *StatementResult {
+			return interceptor.Intercept(stmt, next)
+		}
+	}
+
+	return handler(stmt)
+}
+
+// resultRow returns the row of a QueryRowStatement result.  A result
+// without row or error, returned by an interceptor, has no rows.
+func (m *DbMap) resultRow(res *StatementResult) row {
+	switch {
+	case res.Err != nil:
+		return errRow{res.Err}
+	case res.Row == nil:
+		return errRow{sql.ErrNoRows}
+	}
+	return classifiedRow{res.Row, m}
+}
+
+// classifiedRow classifies the errors of a row, which are only returned
+// once it is scanned.
+type classifiedRow struct {
+	row *sql.Row
+	m   *DbMap
+}
+
+func (r classifiedRow) Scan(dest ...interface{}) error {
+	return r.m.classifyError(r.row.Scan(dest...))
+}
+
+// withTable returns a copy of e reporting table and op as the table and
+// operation of its statements to interceptors, and masking the arguments
+// flagged by sensitive in logs.  Hooks are still run with e, so that their
+// statements are not attributed to table.
+func withTable(e SqlExecutor, table *TableMap, op string, sensitive []bool) SqlExecutor {
+	switch ex := e.(type) {
+	case *DbMap:
+		if ex.stmtTable != table || ex.stmtOp != op || sensitive != nil || ex.stmtSensitive != nil {
+			dup := *ex
+			dup.stmtTable, dup.stmtOp, dup.stmtSensitive = table, op, sensitive
+			return &dup
+		}
+	case *Transaction:
+		if ex.stmtTable != table || ex.stmtOp != op || sensitive != nil || ex.stmtSensitive != nil {
+			dup := *ex
+			dup.stmtTable, dup.stmtOp, dup.stmtSensitive = table, op, sensitive
+			return &dup
+		}
+	}
+	return e
+}
@@ -0,0 +1,380 @@
+package gorp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SchemaIntrospector is implemented by dialects that can read the schema
+// of a live database back.  Table names are passed unquoted, as they
+// appear in TableMap.TableName; an empty schema selects the default
+// schema of the connection.
+//
+// Column and constraint lists are returned in their declared order.
+// Table, column and constraint names are returned as the database
+// reports them, which may differ in case from the mapping.
+type SchemaIntrospector interface {
+	// Tables returns the names of the tables in a schema.
+	Tables(exec SqlExecutor, schema string) ([]string, error)
+
+	// Columns returns the columns of a table, or an empty slice if the
+	// table does not exist.
+	Columns(exec SqlExecutor, schema, table string) ([]ColumnInfo, error)
+
+	// PrimaryKey returns the primary key columns of a table, or an empty
+	// slice if it has none.
+	PrimaryKey(exec SqlExecutor, schema, table string) ([]string, error)
+
+	// Indexes returns the indexes of a table, including those backing
+	// unique constraints but not the primary key index.
+	Indexes(exec SqlExecutor, schema, table string) ([]IndexInfo, error)
+
+	// UniqueConstraints returns the unique constraints of a table.
+	UniqueConstraints(exec SqlExecutor, schema, table string) ([]ConstraintInfo, error)
+
+	// ForeignKeys returns the foreign keys of a table.
+	ForeignKeys(exec SqlExecutor, schema, table string) ([]ForeignKeyInfo, error)
+}
+
+// ColumnInfo describes a column of a live database table.
+type ColumnInfo struct {
+	Name string
+
+	// Type is the column type as reported by the database, for example
+	// "varchar(255)" or "bigint".
+	Type string
+
+	// MaxSize is the size or length given in Type, or 0 if it has none.
+	MaxSize int
+
+	Nullable bool
+
+	// Default is the default expression of the column, as reported by
+	// the database.  It is not valid if the column has no default.
+	Default sql.NullString
+}
+
+// IndexInfo describes an index of a live database table.
+type IndexInfo struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// ConstraintInfo describes a unique constraint of a live database table.
+type ConstraintInfo struct {
+	Name    string
+	Columns []string
+}
+
+// ForeignKeyInfo describes a foreign key of a live database table.
+// OnDelete and OnUpdate hold the referential actions in upper case, for
+// example "CASCADE" or "NO ACTION".
+type ForeignKeyInfo struct {
+	// Name is empty for SQLite, which does not report constraint names.
+	Name       string
+	Columns    []string
+	RefSchema  string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+	OnUpdate   string
+}
+
+// TableInfo gathers everything a SchemaIntrospector reports about a table.
+type TableInfo struct {
+	Schema            string
+	Name              string
+	Columns           []ColumnInfo
+	PrimaryKey        []string
+	Indexes           []IndexInfo
+	UniqueConstraints []ConstraintInfo
+	ForeignKeys       []ForeignKeyInfo
+}
+
+// IntrospectTable reads the definition of a table from the database.  The
+// dialect must implement SchemaIntrospector.  It returns nil if the table
+// does not exist.
+func (m *DbMap) IntrospectTable(schema, table string) (*TableInfo, error) {
+	introspector, ok := m.Dialect.(SchemaIntrospector)
+	if !ok {
+		return nil, errors.New("gorp: dialect does not support schema introspection")
+	}
+	info := &TableInfo{Schema: schema, Name: table}
+	var err error
+	if info.Columns, err = introspector.Columns(m, schema, table); err != nil || len(info.Columns) == 0 {
+		return nil, err
+	}
+	if info.PrimaryKey, err = introspector.PrimaryKey(m, schema, table); err != nil {
+		return nil, err
+	}
+	if info.Indexes, err = introspector.Indexes(m, schema, table); err != nil {
+		return nil, err
+	}
+	if info.UniqueConstraints, err = introspector.UniqueConstraints(m, schema, table); err != nil {
+		return nil, err
+	}
+	if info.ForeignKeys, err = introspector.ForeignKeys(m, schema, table); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// IntrospectSchema reads the definitions of all tables in a schema.  An
+// empty schema selects the default schema of the connection.
+func (m *DbMap) IntrospectSchema(schema string) ([]*TableInfo, error) {
+	introspector, ok := m.Dialect.(SchemaIntrospector)
+	if !ok {
+		return nil, errors.New("gorp: dialect does not support schema introspection")
+	}
+	tables, err := introspector.Tables(m, schema)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*TableInfo, 0, len(tables))
+	for _, table := range tables {
+		info, err := m.IntrospectTable(schema, table)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+var sqlTypeSize = regexp.MustCompile(`^([^(]*)\((\d+)[^)]*\)(.*)$`)
+
+// parseSqlType splits a column type into its base type and size.
+func parseSqlType(sqlType string) (base string, size int) {
+	m := sqlTypeSize.FindStringSubmatch(sqlType)
+	if m == nil {
+		return sqlType, 0
+	}
+	size, _ = strconv.Atoi(m[2])
+	return strings.TrimSpace(m[1] + m[3]), size
+}
+
+// sqlTypeSynonyms maps type names that databases report for a column to
+// the names gorp uses when creating it.
+var sqlTypeSynonyms = map[string]string{
+	"character varying": "varchar",
+	"character":         "char",
+	"int":               "integer",
+	"int4":              "integer",
+	"int8":              "bigint",
+	"serial":            "integer",
+	"bigserial":         "bigint",
+	"bool":              "boolean",
+	"float8":            "double precision",
+	"float4":            "real",
+	"timestamptz":       "timestamp with time zone",
+}
+
+var intDisplayWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+
+// normalizeSqlType returns a canonical form of a column type, so that
+// the type gorp would create a column with can be compared with the type
+// the database reports for it.
+func normalizeSqlType(sqlType string) string {
+	t := strings.Join(strings.Fields(strings.ToLower(sqlType)), " ")
+	if t == "boolean" || t == "bool" {
+		// MySQL stores booleans as tinyint(1)
+		t = "tinyint(1)"
+	}
+	// Integer display widths, as in MySQL's "bigint(20)", do not affect
+	// the type.
+	t = intDisplayWidth.ReplaceAllString(t, "$1")
+	base, size := parseSqlType(t)
+	if synonym, ok := sqlTypeSynonyms[base]; ok {
+		base = synonym
+	}
+	if size > 0 {
+		return base + "(" + strconv.Itoa(size) + ")"
+	}
+	return base
+}
+
+// queryMaps runs query and returns its rows as maps keyed by lower case
+// column name.  []byte values are converted to strings.  It is used to
+// read catalog queries whose result columns vary between database
+// versions.
+func queryMaps(exec SqlExecutor, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := onPrimary(exec).query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var list []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[strings.ToLower(col)] = values[i]
+		}
+		list = append(list, row)
+	}
+	return list, classifyExecError(exec, rows.Err())
+}
+
+// mapString returns row[key] as a string.
+func mapString(row map[string]interface{}, key string) string {
+	switch v := row[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// mapBool returns row[key] as a bool.  Databases without a boolean type
+// report them as integers or as "YES"/"NO" strings.
+func mapBool(row map[string]interface{}, key string) bool {
+	switch v := row[key].(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		switch strings.ToLower(v) {
+		case "1", "t", "true", "y", "yes":
+			return true
+		}
+	}
+	return false
+}
+
+// indexInfos converts rows with "name", "is_unique" and a comma
+// separated "columns" column to IndexInfos.
+func indexInfos(rows []map[string]interface{}) []IndexInfo {
+	indexes := make([]IndexInfo, 0, len(rows))
+	for _, row := range rows {
+		indexes = append(indexes, IndexInfo{
+			Name:    mapString(row, "name"),
+			Unique:  mapBool(row, "is_unique"),
+			Columns: strings.Split(mapString(row, "columns"), ","),
+		})
+	}
+	return indexes
+}
+
+// mapNullString returns row[key] as a sql.NullString, which is not valid
+// if the value is NULL.
+func mapNullString(row map[string]interface{}, key string) sql.NullString {
+	if row[key] == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: mapString(row, key), Valid: true}
+}
+
+// groupRows groups the rows of a query returning one row per member,
+// such as the columns of an index.  Consecutive rows with the same value
+// in group form one group.  For each group it returns the first row and,
+// for each of columns, the values of that column in the group's rows.
+func groupRows(rows []map[string]interface{}, group string, columns ...string) (firsts []map[string]interface{}, members [][][]string) {
+	for i, row := range rows {
+		if i == 0 || mapString(row, group) != mapString(rows[i-1], group) {
+			firsts = append(firsts, row)
+			members = append(members, make([][]string, len(columns)))
+		}
+		last := members[len(members)-1]
+		for j, col := range columns {
+			last[j] = append(last[j], mapString(row, col))
+		}
+	}
+	return firsts, members
+}
+
+// indexRows converts the rows of a query returning one row per indexed
+// column, with "name", "is_unique" and "column_name" columns and ordered
+// by index, to IndexInfos.
+func indexRows(rows []map[string]interface{}) []IndexInfo {
+	firsts, members := groupRows(rows, "name", "column_name")
+	indexes := make([]IndexInfo, len(firsts))
+	for i, row := range firsts {
+		indexes[i] = IndexInfo{
+			Name:    mapString(row, "name"),
+			Unique:  mapBool(row, "is_unique"),
+			Columns: members[i][0],
+		}
+	}
+	return indexes
+}
+
+// constraintRows converts the rows of a query returning one row per
+// constrained column, with "name" and "column_name" columns and ordered
+// by constraint, to ConstraintInfos.
+func constraintRows(rows []map[string]interface{}) []ConstraintInfo {
+	firsts, members := groupRows(rows, "name", "column_name")
+	constraints := make([]ConstraintInfo, len(firsts))
+	for i, row := range firsts {
+		constraints[i] = ConstraintInfo{Name: mapString(row, "name"), Columns: members[i][0]}
+	}
+	return constraints
+}
+
+// foreignKeyRows converts the rows of a query returning one row per
+// foreign key column to ForeignKeyInfos.  Besides "name" and
+// "column_name" the rows hold "ref_schema", "ref_table", "ref_column",
+// "on_delete" and "on_update".
+func foreignKeyRows(rows []map[string]interface{}) []ForeignKeyInfo {
+	firsts, members := groupRows(rows, "name", "column_name", "ref_column")
+	fks := make([]ForeignKeyInfo, len(firsts))
+	for i, row := range firsts {
+		fks[i] = ForeignKeyInfo{
+			Name:       mapString(row, "name"),
+			Columns:    members[i][0],
+			RefSchema:  mapString(row, "ref_schema"),
+			RefTable:   mapString(row, "ref_table"),
+			RefColumns: members[i][1],
+			OnDelete:   referentialAction(mapString(row, "on_delete")),
+			OnUpdate:   referentialAction(mapString(row, "on_update")),
+		}
+	}
+	return fks
+}
+
+// referentialAction normalizes the spellings of referential actions
+// used by the different databases, such as "SET_NULL", to the SQL
+// keywords.
+func referentialAction(action string) string {
+	action = strings.ToUpper(strings.Replace(action, "_", " ", -1))
+	if action == "" || action == "NONE" {
+		return "NO ACTION"
+	}
+	return action
+}
+
+// stringColumn returns the values of key in rows.
+func stringColumn(rows []map[string]interface{}, key string) []string {
+	list := make([]string, len(rows))
+	for i, row := range rows {
+		list[i] = mapString(row, key)
+	}
+	return list
+}
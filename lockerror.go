@@ -39,7 +39,7 @@ func lockError(m *DbMap, exec SqlExecutor, tableName string,
 	existingVer int64, elem reflect.Value,
 	keys ...interface{}) (int64, error) {
 
-	existing, err := get(m, exec, elem.Interface(), keys...)
+	existing, err := get(m, onPrimary(exec), elem.Interface(), keys...)
 	if err != nil {
 		return -1, err
 	}
@@ -1,6 +1,11 @@
 package gorp
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
 
 type GorpLogger interface {
 	Printf(format string, v ...interface{})
@@ -31,3 +36,81 @@ func (m *DbMap) TraceOff() {
 	m.logger = nil
 	m.logPrefix = ""
 }
+
+// StructuredLogger receives a record with fields for every statement.  It
+// is satisfied by *slog.Logger, and can be adapted to other structured
+// logging packages.
+//
+// The fields are "query", "args", "duration", "rows" (the rows affected
+// by exec statements), "error", "table", "operation" and "tx" (the
+// Statement.TxID), each only if known.
+type StructuredLogger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...interface{})
+}
+
+// SetLogger turns on structured logging of the statements run through
+// this DbMap and its transactions, as sent to the database after the
+// interceptors ran.  Failed statements are logged at slog.LevelError and
+// successful ones at slog.LevelDebug, or not at all if they took less
+// than the slow threshold.  Begin, Commit and Rollback are logged too.
+//
+// A nil logger turns structured logging off.  TraceOn is independent of
+// SetLogger.
+func (m *DbMap) SetLogger(logger StructuredLogger) {
+	m.structLogger = logger
+}
+
+// SetSlowThreshold restricts structured logging to statements taking at
+// least d, which are logged at slog.LevelWarn.  Failed statements are
+// always logged.  A threshold of 0 logs all statements.
+func (m *DbMap) SetSlowThreshold(d time.Duration) {
+	m.slowThreshold = d
+}
+
+// logStatements returns a handler logging the statements run by next.
+func (m *DbMap) logStatements(next StatementHandler) StatementHandler {
+	return func(stmt *Statement) *StatementResult {
+		started := time.Now()
+		res := next(stmt)
+		elapsed := time.Since(started)
+
+		args := m.logArgs(stmt.Query, stmt.Sensitive, stmt.Args)
+		fields := []interface{}{"query", stmt.Query, "args", args, "duration", elapsed}
+		if res.Err == nil && res.Result != nil {
+			if rows, err := res.Result.RowsAffected(); err == nil {
+				fields = append(fields, "rows", rows)
+			}
+		}
+		if stmt.Table != nil {
+			fields = append(fields, "table", stmt.Table.TableName)
+		}
+		op := stmt.Operation
+		if op == "" {
+			op = stmt.Kind.String()
+		}
+		fields = append(fields, "operation", op)
+		m.logEvent(stmt.Context, stmt.TxID, "statement", elapsed, res.Err, fields)
+		return res
+	}
+}
+
+// logEvent logs msg to the structured logger at the level matching err
+// and elapsed, adding the "error" and "tx" fields.
+func (m *DbMap) logEvent(ctx context.Context, txID uint64, msg string, elapsed time.Duration, err error, fields []interface{}) {
+	level := slog.LevelDebug
+	switch {
+	case err != nil:
+		level = slog.LevelError
+		msg += " failed"
+		fields = append(fields, "error", err)
+	case m.slowThreshold > 0 && elapsed < m.slowThreshold:
+		return
+	case m.slowThreshold > 0:
+		level = slog.LevelWarn
+		msg = "slow " + msg
+	}
+	if txID != 0 {
+		fields = append(fields, "tx", txID)
+	}
+	m.structLogger.Log(ctx, level, "gorp: "+msg, fields...)
+}
@@ -0,0 +1,161 @@
+package gorp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MetricsCollector receives an observation for every statement run
+// through a DbMap and its transactions, so that it can be exported to a
+// monitoring system.
+//
+// op is the Statement.Operation, or "exec" and "select" for statements
+// that were not generated by gorp.  table is the name of the
+// Statement.Table, or empty.  err is the error of the statement, if any.
+// Observe is called concurrently by concurrent statements.
+type MetricsCollector interface {
+	Observe(op, table string, elapsed time.Duration, err error)
+}
+
+// SetMetrics sets the collector observing the statements run through
+// this DbMap and its transactions, as sent to the database after the
+// interceptors ran.  A nil collector turns metrics off.
+func (m *DbMap) SetMetrics(c MetricsCollector) {
+	m.metrics = c
+}
+
+// observeStatements returns a handler reporting the statements run by
+// next to the metrics collector.
+func (m *DbMap) observeStatements(next StatementHandler) StatementHandler {
+	return func(stmt *Statement) *StatementResult {
+		started := time.Now()
+		res := next(stmt)
+		elapsed := time.Since(started)
+
+		op := stmt.Operation
+		if op == "" {
+			op = "select"
+			if stmt.Kind == ExecStatement {
+				op = "exec"
+			}
+		}
+		table := ""
+		if stmt.Table != nil {
+			table = stmt.Table.TableName
+		}
+		m.metrics.Observe(op, table, elapsed, res.Err)
+		return res
+	}
+}
+
+// DefaultMetricsBuckets are the latency histogram buckets of a
+// MemoryMetrics created without buckets.
+var DefaultMetricsBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// MetricsKey identifies the statements a MemoryMetrics aggregates
+// together.
+type MetricsKey struct {
+	Operation string
+	Table     string
+}
+
+// OperationMetrics aggregates the statements of a MetricsKey.
+type OperationMetrics struct {
+	// Number of statements, and how many of them failed.
+	Count  int64
+	Errors int64
+
+	// Total time spent running the statements.
+	Sum time.Duration
+
+	// Latency histogram: BucketCounts[i] is the number of statements
+	// that took at most Buckets[i].  Count includes the statements
+	// slower than the last bucket.  Counts are cumulative, as expected
+	// by Prometheus.
+	Buckets      []time.Duration
+	BucketCounts []int64
+}
+
+// MemoryMetrics is a MetricsCollector keeping counts, error counts and
+// latency histograms per operation and table in memory, for an exporter
+// to read with Snapshot.  It is safe for concurrent use.
+//
+//	metrics := gorp.NewMemoryMetrics()
+//	dbmap.SetMetrics(metrics)
+//	...
+//	for key, m := range metrics.Snapshot() {
+//		fmt.Println(key.Operation, key.Table, m.Count, m.Errors, m.Sum)
+//	}
+type MemoryMetrics struct {
+	buckets []time.Duration
+
+	mu     sync.Mutex
+	series map[MetricsKey]*OperationMetrics
+}
+
+// NewMemoryMetrics returns a MemoryMetrics with the given latency
+// histogram buckets, or DefaultMetricsBuckets if there are none.
+func NewMemoryMetrics(buckets ...time.Duration) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+	b := append([]time.Duration(nil), buckets...)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return &MemoryMetrics{buckets: b, series: make(map[MetricsKey]*OperationMetrics)}
+}
+
+// Observe implements MetricsCollector.
+func (mm *MemoryMetrics) Observe(op, table string, elapsed time.Duration, err error) {
+	key := MetricsKey{Operation: op, Table: table}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	s := mm.series[key]
+	if s == nil {
+		s = &OperationMetrics{Buckets: mm.buckets, BucketCounts: make([]int64, len(mm.buckets))}
+		mm.series[key] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.Sum += elapsed
+	for i := sort.Search(len(mm.buckets), func(i int) bool { return elapsed <= mm.buckets[i] }); i < len(mm.buckets); i++ {
+		s.BucketCounts[i]++
+	}
+}
+
+// Snapshot returns a copy of the metrics observed since the
+// MemoryMetrics was created or last reset.
+func (mm *MemoryMetrics) Snapshot() map[MetricsKey]OperationMetrics {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	snap := make(map[MetricsKey]OperationMetrics, len(mm.series))
+	for key, s := range mm.series {
+		m := *s
+		m.BucketCounts = append([]int64(nil), s.BucketCounts...)
+		snap[key] = m
+	}
+	return snap
+}
+
+// Reset discards all observed metrics.
+func (mm *MemoryMetrics) Reset() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.series = make(map[MetricsKey]*OperationMetrics)
+}
@@ -0,0 +1,418 @@
+package gorp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrMigrationLocked is returned by Migrator when another process holds
+// the migration lock.  If a migrating process died without releasing the
+// lock, it can be cleared with Migrator.Unlock.
+var ErrMigrationLocked = errors.New("gorp: migrations are locked by another process")
+
+// Migration is a single versioned schema change.  Each direction is
+// either a Go function or SQL text; the function takes precedence if
+// both are set.
+type Migration struct {
+	// Id identifies the migration in the migrations table.  Migrations
+	// are applied in the lexical order of their ids, so ids usually
+	// start with a zero-padded sequence number or a timestamp, as in
+	// "0001_create_invoices".
+	Id string
+
+	Up   func(SqlExecutor) error
+	Down func(SqlExecutor) error
+
+	// UpSql and DownSql hold one or more statements separated by ";".
+	UpSql   string
+	DownSql string
+
+	// Checksum is recorded when the migration is applied, and compared
+	// with the recorded value on every later run so that edits to
+	// applied migrations are detected.  If empty, the SHA-256 of UpSql is
+	// used.  Migrations with an Up function and no Checksum are not
+	// checked.
+	Checksum string
+}
+
+func (mig *Migration) checksum() string {
+	if mig.Checksum != "" || mig.Up != nil {
+		return mig.Checksum
+	}
+	sum := sha256.Sum256([]byte(mig.UpSql))
+	return hex.EncodeToString(sum[:])
+}
+
+func (mig *Migration) run(exec SqlExecutor, fn func(SqlExecutor) error, sql string) error {
+	if fn != nil {
+		return fn(exec)
+	}
+	for _, stmt := range splitSqlStatements(sql) {
+		_, err := exec.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("gorp: migration %s failed: %w", mig.Id, err)
+		}
+	}
+	return nil
+}
+
+// migrationRecord is a row of the migrations table.
+type migrationRecord struct {
+	Id       string `db:"id, size:255"`
+	Checksum string `db:"checksum, size:64"`
+	// AppliedAt is the time the migration was applied, in seconds since
+	// the Unix epoch.
+	AppliedAt int64 `db:"applied_at"`
+}
+
+type migrationLock struct {
+	Id       int64 `db:"id"`
+	LockedAt int64 `db:"locked_at"`
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Migration *Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts Migrations against the database of a
+// DbMap, recording the applied ones in a table it manages itself.  A
+// second table holds a lock, so that two processes cannot migrate the
+// same database at once.
+//
+// Each migration runs in its own transaction, together with the update of
+// the migrations table.  Note that some databases, such as MySQL, commit
+// implicitly after schema changes.
+type Migrator struct {
+	// TableName is the name of the migrations table.  The lock table is
+	// named after it, with a "_lock" suffix.
+	TableName string
+
+	dbmap      *DbMap
+	records    *DbMap
+	migrations []*Migration
+}
+
+// NewMigrator returns a Migrator for the given migrations, which may be
+// passed in any order.  The migrations table is named "gorp_migrations".
+func NewMigrator(m *DbMap, migrations ...*Migration) *Migrator {
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Sort(migrationsById(sorted))
+	return &Migrator{TableName: "gorp_migrations", dbmap: m, migrations: sorted}
+}
+
+type migrationsById []*Migration
+
+func (a migrationsById) Len() int           { return len(a) }
+func (a migrationsById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a migrationsById) Less(i, j int) bool { return a[i].Id < a[j].Id }
+
+// recordsMap returns a DbMap sharing the database of the migrated DbMap,
+// with the migrations and lock tables registered.  The tables are kept
+// off the migrated DbMap so that its CreateTables and DropTables do not
+// touch them.  Their statements are logged, intercepted and measured as
+// those of the migrated DbMap.
+func (mg *Migrator) recordsMap() *DbMap {
+	if mg.records == nil {
+		mg.records = &DbMap{Db: mg.dbmap.Db, Dialect: mg.dbmap.Dialect}
+		mg.records.AddTableWithName(migrationRecord{}, mg.TableName).SetKeys(false, "Id")
+		mg.records.AddTableWithName(migrationLock{}, mg.TableName+"_lock").SetKeys(false, "Id")
+	}
+	m, records := mg.dbmap, mg.records
+	records.logger, records.logPrefix = m.logger, m.logPrefix
+	records.structLogger, records.slowThreshold = m.structLogger, m.slowThreshold
+	records.interceptors = m.interceptors
+	records.redactor = m.redactor
+	records.metrics = m.metrics
+	return records
+}
+
+// Up applies all pending migrations in order, and returns the number of
+// migrations applied.
+func (mg *Migrator) Up() (int, error) {
+	return mg.UpContext(context.Background())
+}
+
+// UpContext has the same behavior as Up, but runs with ctx.
+func (mg *Migrator) UpContext(ctx context.Context) (int, error) {
+	var applied int
+	err := mg.locked(ctx, func(records map[string]*migrationRecord) error {
+		for _, mig := range mg.migrations {
+			if _, ok := records[mig.Id]; ok {
+				continue
+			}
+			err := mg.apply(ctx, mig, true)
+			if err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns the number of migrations reverted.
+func (mg *Migrator) Down(steps int) (int, error) {
+	return mg.DownContext(context.Background(), steps)
+}
+
+// DownContext has the same behavior as Down, but runs with ctx.
+func (mg *Migrator) DownContext(ctx context.Context, steps int) (int, error) {
+	var reverted int
+	err := mg.locked(ctx, func(records map[string]*migrationRecord) error {
+		for i := len(mg.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := mg.migrations[i]
+			if _, ok := records[mig.Id]; !ok {
+				continue
+			}
+			if mig.Down == nil && mig.DownSql == "" {
+				return fmt.Errorf("gorp: migration %s cannot be reverted", mig.Id)
+			}
+			err := mg.apply(ctx, mig, false)
+			if err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status returns the status of every migration, in the order they are
+// applied.
+func (mg *Migrator) Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	err := mg.recordsMap().CreateTablesIfNotExists()
+	if err != nil {
+		return nil, err
+	}
+	records, err := mg.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(mg.migrations))
+	for i, mig := range mg.migrations {
+		status[i].Migration = mig
+		if rec, ok := records[mig.Id]; ok {
+			status[i].Applied = true
+			status[i].AppliedAt = time.Unix(rec.AppliedAt, 0)
+		}
+	}
+	return status, nil
+}
+
+// Unlock clears the migration lock.  Only use it after making sure that
+// no other process is migrating the database.
+func (mg *Migrator) Unlock() error {
+	_, err := mg.recordsMap().Delete(&migrationLock{Id: 1})
+	return err
+}
+
+// locked runs fn while holding the migration lock, after verifying the
+// checksums of the applied migrations.
+func (mg *Migrator) locked(ctx context.Context, fn func(map[string]*migrationRecord) error) (err error) {
+	records := mg.recordsMap()
+	err = records.CreateTablesIfNotExists()
+	if err != nil {
+		return err
+	}
+
+	err = records.InsertContext(ctx, &migrationLock{Id: 1, LockedAt: time.Now().Unix()})
+	if err != nil {
+		// The insert fails on the primary key if the lock is held,
+		// anything else is reported as is.
+		lock, getErr := records.GetContext(ctx, migrationLock{}, 1)
+		if getErr == nil && lock != nil {
+			return ErrMigrationLocked
+		}
+		return err
+	}
+	defer func() {
+		_, unlockErr := records.Delete(&migrationLock{Id: 1})
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	applied, err := mg.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mig := range mg.migrations {
+		rec, ok := applied[mig.Id]
+		if !ok {
+			continue
+		}
+		if sum := mig.checksum(); sum != "" && rec.Checksum != "" && sum != rec.Checksum {
+			return fmt.Errorf("gorp: migration %s has changed since it was applied (checksum %s, recorded %s)", mig.Id, sum, rec.Checksum)
+		}
+	}
+	return fn(applied)
+}
+
+func (mg *Migrator) appliedRecords(ctx context.Context) (map[string]*migrationRecord, error) {
+	var list []*migrationRecord
+	records := mg.recordsMap()
+	_, err := records.SelectContext(ctx, &list, fmt.Sprintf("select * from %s",
+		records.Dialect.QuotedTableForQuery("", mg.TableName)))
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]*migrationRecord, len(list))
+	for _, rec := range list {
+		applied[rec.Id] = rec
+	}
+	return applied, nil
+}
+
+// apply runs one direction of mig, and records the result, in a single
+// transaction.
+func (mg *Migrator) apply(ctx context.Context, mig *Migration, up bool) error {
+	tx, err := mg.dbmap.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// The records table belongs to another DbMap, so bind it to the
+	// same database transaction.
+	recordsTx := &Transaction{dbmap: mg.recordsMap(), tx: tx.tx, conn: tx.conn, ctx: tx.ctx, id: tx.id,
+		state: tx.state}
+
+	rec := &migrationRecord{Id: mig.Id, Checksum: mig.checksum(), AppliedAt: time.Now().Unix()}
+	if up {
+		err = mig.run(tx, mig.Up, mig.UpSql)
+		if err == nil {
+			err = recordsTx.Insert(rec)
+		}
+	} else {
+		err = mig.run(tx, mig.Down, mig.DownSql)
+		if err == nil {
+			_, err = recordsTx.Delete(rec)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrationsFromFS reads SQL migrations from the files in dir.  Each
+// migration consists of an "<id>.up.sql" file and an optional
+// "<id>.down.sql" file.  Other files are ignored.
+func MigrationsFromFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*Migration)
+	var migrations []*Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		var id string
+		var up bool
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			id, up = strings.TrimSuffix(name, ".up.sql"), true
+		case strings.HasSuffix(name, ".down.sql"):
+			id = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byId[id]
+		if !ok {
+			mig = &Migration{Id: id}
+			byId[id] = mig
+			migrations = append(migrations, mig)
+		}
+		if up {
+			mig.UpSql = string(b)
+		} else {
+			mig.DownSql = string(b)
+		}
+	}
+
+	for _, mig := range migrations {
+		if mig.UpSql == "" {
+			return nil, fmt.Errorf("gorp: migration %s has no .up.sql file", mig.Id)
+		}
+	}
+	return migrations, nil
+}
+
+// splitSqlStatements splits sql on the semicolons that end its
+// statements.  Semicolons in quoted strings, quoted identifiers and
+// comments are ignored.  Empty statements are dropped.
+func splitSqlStatements(sql string) []string {
+	var (
+		stmts   []string
+		start   int
+		quote   byte
+		comment byte
+	)
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case comment == '-':
+			if c == '\n' {
+				comment = 0
+			}
+		case comment == '*':
+			if c == '*' && i+1 < len(sql) && sql[i+1] == '/' {
+				comment = 0
+				i++
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			comment = '-'
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			comment = '*'
+		case c == ';':
+			stmts = appendStatement(stmts, sql[start:i])
+			start = i + 1
+		}
+	}
+	return appendStatement(stmts, sql[start:])
+}
+
+func appendStatement(stmts []string, stmt string) []string {
+	if stmt = strings.TrimSpace(stmt); stmt != "" && !isSqlComment(stmt) {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// isSqlComment reports whether stmt consists only of "--" comments.
+func isSqlComment(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,464 @@
+package gorp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Query builds a select statement against a mapped table.  Create one with
+// DbMap.Query or Transaction.Query, refine it with the chainable methods
+// and run it with Select, SelectOne or SelectIter:
+//
+//	var invoices []*Invoice
+//	_, err := dbmap.Query(Invoice{}).
+//		Where("PersonId = ?", p.Id).
+//		And("Created >= ?", since).
+//		OrderBy("Created desc").
+//		Limit(10).
+//		Select(&invoices)
+//
+// Conditions and other expressions are plain SQL with "?" placeholders,
+// which are replaced with the dialect's bind variables.  Field names of
+// the queried and joined tables, given as struct field or column names and
+// optionally qualified with the table name or the struct type name, are
+// replaced with the quoted column, so the same query runs on every
+// dialect.  A slice argument expands to a list of bind variables, for use
+// with "in (?)"; an empty slice expands to null.
+//
+// Methods record the first error, such as an unmapped join type, and
+// return it when the query is run.
+type Query struct {
+	exec    SqlExecutor
+	dbmap   *DbMap
+	table   *TableMap
+	joined  []*TableMap
+	columns []string
+	joins   []queryClause
+	where   queryCondition
+	groupBy []string
+	having  queryCondition
+	orderBy []string
+	limit   int
+	offset  int
+	preload []string
+	err     error
+}
+
+// queryClause is an SQL fragment with "?" placeholders and its arguments.
+type queryClause struct {
+	sql  string
+	args []interface{}
+}
+
+// queryCondition combines conditions with "and" and "or" from left to
+// right.
+type queryCondition struct {
+	queryClause
+	op string
+}
+
+func (c *queryCondition) add(op, cond string, args []interface{}) {
+	if c.sql == "" {
+		c.sql = cond
+	} else {
+		if c.op != "" && c.op != op {
+			c.sql = "(" + c.sql + ")"
+		}
+		if containsLogicalOp(cond) {
+			cond = "(" + cond + ")"
+		}
+		if c.op == "" && containsLogicalOp(c.sql) {
+			c.sql = "(" + c.sql + ")"
+		}
+		c.sql += " " + op + " " + cond
+		c.op = op
+	}
+	c.args = append(c.args, args...)
+}
+
+func containsLogicalOp(cond string) bool {
+	lower := " " + strings.ToLower(cond) + " "
+	return strings.Contains(lower, " or ") || strings.Contains(lower, " and ")
+}
+
+func newQuery(m *DbMap, exec SqlExecutor, i interface{}) *Query {
+	q := &Query{exec: exec, dbmap: m}
+	t, err := toType(i)
+	if err == nil {
+		q.table, err = m.TableFor(t, false)
+	}
+	q.err = err
+	return q
+}
+
+// Columns sets the expressions to select.  By default all columns of the
+// queried table are selected.
+func (q *Query) Columns(exprs ...string) *Query {
+	q.columns = append([]string(nil), exprs...)
+	return q
+}
+
+// Join adds an inner join with the table mapped to i.  Fields of the
+// joined table can be used in all expressions of the query.
+func (q *Query) Join(i interface{}, on string, args ...interface{}) *Query {
+	return q.join("join", i, on, args)
+}
+
+// LeftJoin adds a left outer join with the table mapped to i.
+func (q *Query) LeftJoin(i interface{}, on string, args ...interface{}) *Query {
+	return q.join("left join", i, on, args)
+}
+
+func (q *Query) join(kind string, i interface{}, on string, args []interface{}) *Query {
+	if q.err != nil {
+		return q
+	}
+	t, err := toType(i)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	table, err := q.dbmap.TableFor(t, false)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	q.joined = append(q.joined, table)
+	q.joins = append(q.joins, queryClause{
+		sql:  fmt.Sprintf("%s %s on %s", kind, q.quotedTable(table), on),
+		args: args,
+	})
+	return q
+}
+
+// Where adds a condition rows must match.  It is the same as And.
+func (q *Query) Where(cond string, args ...interface{}) *Query {
+	return q.And(cond, args...)
+}
+
+// And adds a condition rows must match as well as the previous ones.
+func (q *Query) And(cond string, args ...interface{}) *Query {
+	q.where.add("and", cond, args)
+	return q
+}
+
+// Or adds a condition rows may match instead of the previous ones.
+// Conditions combine from left to right, so
+// Where(a).Or(b).And(c) selects rows matching (a or b) and c.
+func (q *Query) Or(cond string, args ...interface{}) *Query {
+	q.where.add("or", cond, args)
+	return q
+}
+
+// GroupBy adds expressions to group the rows by.
+func (q *Query) GroupBy(exprs ...string) *Query {
+	q.groupBy = append(q.groupBy, exprs...)
+	return q
+}
+
+// Having adds a condition the groups must match.  Repeated calls are
+// combined with "and".
+func (q *Query) Having(cond string, args ...interface{}) *Query {
+	q.having.add("and", cond, args)
+	return q
+}
+
+// OrderBy adds expressions to sort the rows by, each optionally followed
+// by "asc" or "desc".
+func (q *Query) OrderBy(exprs ...string) *Query {
+	q.orderBy = append(q.orderBy, exprs...)
+	return q
+}
+
+// Limit restricts the query to return at most n rows.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first n rows.  On SQL Server it requires OrderBy.
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Preload loads the named relations of the selected records after Select
+// or SelectOne.  See DbMap.Preload.
+func (q *Query) Preload(relations ...string) *Query {
+	q.preload = append(q.preload, relations...)
+	return q
+}
+
+// ToSql returns the statement and its arguments.
+func (q *Query) ToSql() (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	if q.table == nil {
+		return "", nil, errors.New("gorp: query has no table")
+	}
+
+	dialect := q.dbmap.Dialect
+	s := bytes.Buffer{}
+	var args []interface{}
+	write := func(sql string, clauseArgs []interface{}) {
+		s.WriteString(q.resolve(sql))
+		args = append(args, clauseArgs...)
+	}
+
+	s.WriteString("select ")
+	if len(q.columns) > 0 {
+		write(strings.Join(q.columns, ", "), nil)
+	} else {
+		x := 0
+		for _, col := range q.table.Columns {
+			if col.Transient {
+				continue
+			}
+			if x > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(q.quotedTable(q.table) + "." + dialect.QuoteField(col.ColumnName))
+			x++
+		}
+	}
+	s.WriteString(" from " + q.quotedTable(q.table))
+	for _, join := range q.joins {
+		s.WriteString(" ")
+		write(join.sql, join.args)
+	}
+	where := q.where
+	if q.table.softDelete != nil && !includeDeleted(q.exec) {
+		cond, condArgs := q.table.notDeletedCond("?")
+		if where.sql != "" {
+			where.sql = "(" + where.sql + ")"
+		}
+		where.add("and", q.quotedTable(q.table)+"."+cond, condArgs)
+	}
+	if where.sql != "" {
+		s.WriteString(" where ")
+		write(where.sql, where.args)
+	}
+	if len(q.groupBy) > 0 {
+		s.WriteString(" group by ")
+		write(strings.Join(q.groupBy, ", "), nil)
+	}
+	if q.having.sql != "" {
+		s.WriteString(" having ")
+		write(q.having.sql, q.having.args)
+	}
+	if len(q.orderBy) > 0 {
+		s.WriteString(" order by ")
+		write(strings.Join(q.orderBy, ", "), nil)
+	}
+	if q.limit > 0 || q.offset > 0 {
+		if lo, ok := dialect.(LimitOffsetter); ok {
+			s.WriteString(lo.LimitOffset(q.limit, q.offset))
+		} else {
+			if q.limit > 0 {
+				s.WriteString(fmt.Sprintf(" limit %d", q.limit))
+			}
+			if q.offset > 0 {
+				s.WriteString(fmt.Sprintf(" offset %d", q.offset))
+			}
+		}
+	}
+
+	sql, args := bindQueryArgs(dialect, s.String(), args)
+	return sql + dialect.QuerySuffix(), args, nil
+}
+
+// Select runs the query with the same behavior as SqlExecutor.Select.
+// i may be nil to select into the queried table's type.
+func (q *Query) Select(i interface{}) ([]interface{}, error) {
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if i == nil {
+		i = reflect.New(q.table.gotype).Interface()
+	}
+	list, err := q.exec.Select(i, sql, args...)
+	if err != nil || len(q.preload) == 0 {
+		return list, err
+	}
+	if t, _ := toSliceType(i); t != nil {
+		err = preload(q.dbmap, q.exec, i, q.preload)
+	} else {
+		err = preload(q.dbmap, q.exec, list, q.preload)
+	}
+	return list, err
+}
+
+// SelectOne runs the query with the same behavior as
+// SqlExecutor.SelectOne.
+func (q *Query) SelectOne(holder interface{}) error {
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+	err = q.exec.SelectOne(holder, sql, args...)
+	if err != nil || len(q.preload) == 0 {
+		return err
+	}
+	return preload(q.dbmap, q.exec, holder, q.preload)
+}
+
+// SelectIter runs the query with the same behavior as
+// SqlExecutor.SelectIter.  i may be nil to iterate over values of the
+// queried table's type.
+func (q *Query) SelectIter(i interface{}) (*SelectIterator, error) {
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if i == nil {
+		i = reflect.New(q.table.gotype).Interface()
+	}
+	return q.exec.SelectIter(i, sql, args...)
+}
+
+func (q *Query) quotedTable(t *TableMap) string {
+	return q.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)
+}
+
+// queryKeywords are never resolved as field names.
+var queryKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "like": true, "in": true, "is": true,
+	"null": true, "between": true, "asc": true, "desc": true, "as": true,
+	"on": true, "exists": true, "true": true, "false": true, "case": true,
+	"when": true, "then": true, "else": true, "end": true, "distinct": true,
+}
+
+// resolve replaces the field names in expr with quoted, table qualified
+// columns.  Quoted strings and identifiers, function names and aliases
+// following "as" are left alone.
+func (q *Query) resolve(expr string) string {
+	s := bytes.Buffer{}
+	prev := ""
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := i + 1
+			for j < len(expr) && expr[j] != end {
+				j++
+			}
+			if j < len(expr) {
+				j++
+			}
+			s.WriteString(expr[i:j])
+			i = j
+			prev = ""
+		case isIdentStart(c):
+			j := i
+			for j < len(expr) && (isIdentStart(expr[j]) || isDigit(expr[j]) || expr[j] == '.') {
+				j++
+			}
+			word := expr[i:j]
+			k := j
+			for k < len(expr) && expr[k] == ' ' {
+				k++
+			}
+			isCall := k < len(expr) && expr[k] == '('
+			if !isCall && !strings.EqualFold(prev, "as") {
+				word = q.resolveField(word)
+			}
+			s.WriteString(word)
+			prev = expr[i:j]
+			i = j
+		default:
+			s.WriteByte(c)
+			if c != ' ' {
+				prev = ""
+			}
+			i++
+		}
+	}
+	return s.String()
+}
+
+// resolveField returns the quoted column for a field name, optionally
+// qualified with a table or type name, or name itself if it does not
+// name a field of the queried or joined tables.
+func (q *Query) resolveField(name string) string {
+	if queryKeywords[strings.ToLower(name)] {
+		return name
+	}
+	tables := append([]*TableMap{q.table}, q.joined...)
+	qualifier := ""
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		qualifier, name = name[:dot], name[dot+1:]
+	}
+	for _, t := range tables {
+		if qualifier != "" && !strings.EqualFold(qualifier, t.TableName) &&
+			!strings.EqualFold(qualifier, t.gotype.Name()) {
+			continue
+		}
+		for _, col := range t.Columns {
+			if !col.Transient && (strings.EqualFold(col.fieldName, name) || strings.EqualFold(col.ColumnName, name)) {
+				return q.quotedTable(t) + "." + q.dbmap.Dialect.QuoteField(col.ColumnName)
+			}
+		}
+	}
+	if qualifier != "" {
+		return qualifier + "." + name
+	}
+	return name
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// bindQueryArgs replaces the "?" placeholders outside quotes in query with
+// the dialect's bind variables, expanding slice arguments into lists.
+func bindQueryArgs(dialect Dialect, query string, args []interface{}) (string, []interface{}) {
+	s := bytes.Buffer{}
+	var bound []interface{}
+	arg := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && arg < len(args):
+			v := reflect.ValueOf(args[arg])
+			arg++
+			if (v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8) || v.Kind() == reflect.Array {
+				if v.Len() == 0 {
+					s.WriteString("null")
+				}
+				for j := 0; j < v.Len(); j++ {
+					if j > 0 {
+						s.WriteString(", ")
+					}
+					s.WriteString(dialect.BindVar(len(bound)))
+					bound = append(bound, v.Index(j).Interface())
+				}
+			} else {
+				s.WriteString(dialect.BindVar(len(bound)))
+				bound = append(bound, args[arg-1])
+			}
+			continue
+		}
+		s.WriteByte(c)
+	}
+	return s.String(), bound
+}
@@ -0,0 +1,86 @@
+package gorp
+
+// Redacted stands in for masked arguments in traced and logged statements.
+type Redacted struct{}
+
+func (Redacted) String() string {
+	return "[redacted]"
+}
+
+// MarshalText makes structured loggers print Redacted like String does.
+func (r Redacted) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Redactor masks the arguments of statements before they are traced with
+// TraceOn or logged with SetLogger.  Redact returns the arguments to log
+// in place of args, usually replacing some of them with Redacted{}.  It
+// must not modify args, which are still sent to the database.
+//
+// Arguments bound to sensitive columns by generated statements are
+// already masked when the Redactor sees them, so it is mostly useful for
+// queries written by hand:
+//
+//	dbmap.SetRedactor(gorp.RedactorFunc(func(query string, args []interface{}) []interface{} {
+//		if strings.Contains(query, "password") {
+//			return nil
+//		}
+//		return args
+//	}))
+type Redactor interface {
+	Redact(query string, args []interface{}) []interface{}
+}
+
+// The RedactorFunc type is an adapter to allow the use of ordinary
+// functions as a Redactor.
+type RedactorFunc func(query string, args []interface{}) []interface{}
+
+// Redact calls f(query, args).
+func (f RedactorFunc) Redact(query string, args []interface{}) []interface{} {
+	return f(query, args)
+}
+
+// SetRedactor sets the Redactor masking the arguments of every traced and
+// logged statement.  A nil redactor only masks sensitive columns.
+func (m *DbMap) SetRedactor(r Redactor) {
+	m.redactor = r
+}
+
+// logArgs returns the args of query as they may be logged, masking those
+// flagged by sensitive and passing the rest through the redactor.
+func (m *DbMap) logArgs(query string, sensitive []bool, args []interface{}) []interface{} {
+	for i, s := range sensitive {
+		if s && i < len(args) {
+			masked := make([]interface{}, len(args))
+			copy(masked, args)
+			for j := i; j < len(sensitive) && j < len(args); j++ {
+				if sensitive[j] {
+					masked[j] = Redacted{}
+				}
+			}
+			args = masked
+			break
+		}
+	}
+	if m.redactor != nil {
+		args = m.redactor.Redact(query, args)
+	}
+	return args
+}
+
+// sensitiveArgs returns which of fields are mapped to sensitive columns,
+// or nil if none are.
+func (t *TableMap) sensitiveArgs(fields []string) []bool {
+	var sensitive []bool
+	for i, field := range fields {
+		col := colMapOrNil(t, field)
+		if col == nil || !col.Sensitive || col.Transient {
+			continue
+		}
+		if sensitive == nil {
+			sensitive = make([]bool, len(fields))
+		}
+		sensitive[i] = true
+	}
+	return sensitive
+}
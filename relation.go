@@ -0,0 +1,343 @@
+package gorp
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type relationKind int
+
+const (
+	hasOne relationKind = iota
+	hasMany
+	belongsTo
+)
+
+// RelationMap represents an association between a mapped table and another
+// one, loaded into a struct field by Preload.
+//
+// For HasOne and HasMany relations the related table holds the foreign
+// key; for BelongsTo relations the table itself does.
+type RelationMap struct {
+	// Name of the struct field the related records are loaded into.
+	FieldName string
+
+	// Foreign key column, as a field or column name.  It is looked up
+	// among the foreign keys of the table holding it if empty.
+	ForeignKey string
+
+	// Referenced column, as a field or column name.  The primary key of
+	// the referenced table is used if empty.
+	References string
+
+	kind    relationKind
+	table   *TableMap
+	relType reflect.Type
+}
+
+// HasOne declares that a row of the table has at most one related row in
+// the table mapped to the type of field, which must be a struct or a
+// pointer to one.  fk optionally names the foreign key field of the
+// related table; see RelationMap.ForeignKey.
+//
+// The field is marked transient.  Panics if it does not exist.
+func (t *TableMap) HasOne(field string, fk ...string) *RelationMap {
+	return t.addRelation(hasOne, field, fk)
+}
+
+// HasMany declares that a row of the table has any number of related rows
+// in the table mapped to the element type of field, which must be a slice
+// of structs or of pointers to structs.  fk optionally names the foreign
+// key field of the related table.
+//
+// The field is marked transient.  Panics if it does not exist.
+func (t *TableMap) HasMany(field string, fk ...string) *RelationMap {
+	return t.addRelation(hasMany, field, fk)
+}
+
+// BelongsTo declares that a row of the table references a row of the
+// table mapped to the type of field, which must be a struct or a pointer
+// to one.  fk optionally names the foreign key field of this table.
+//
+// The field is marked transient.  Panics if it does not exist.
+func (t *TableMap) BelongsTo(field string, fk ...string) *RelationMap {
+	return t.addRelation(belongsTo, field, fk)
+}
+
+func (t *TableMap) addRelation(kind relationKind, field string, fk []string) *RelationMap {
+	t.checkFrozen("add relation " + field)
+	f, ok := t.gotype.FieldByName(field)
+	if !ok {
+		panic(fmt.Sprintf("gorp: no field %s in type %s", field, t.gotype.Name()))
+	}
+	relType := f.Type
+	if kind == hasMany {
+		if relType.Kind() != reflect.Slice {
+			panic(fmt.Sprintf("gorp: HasMany field %s must be a slice", field))
+		}
+		relType = relType.Elem()
+	}
+	if relType.Kind() == reflect.Ptr {
+		relType = relType.Elem()
+	}
+	if relType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("gorp: relation field %s must hold structs", field))
+	}
+
+	if col := colMapOrNil(t, field); col != nil {
+		col.SetTransient(true)
+		t.ResetSql()
+	}
+	rel := &RelationMap{FieldName: field, kind: kind, table: t, relType: relType}
+	if len(fk) > 0 {
+		rel.ForeignKey = fk[0]
+	}
+	t.relations = append(t.relations, rel)
+	return rel
+}
+
+// SetReferences sets the referenced column of the relation.
+func (r *RelationMap) SetReferences(field string) *RelationMap {
+	r.References = field
+	return r
+}
+
+// Relation returns the relation loaded into field, or nil if there is
+// none.
+func (t *TableMap) Relation(field string) *RelationMap {
+	for _, rel := range t.relations {
+		if rel.FieldName == field {
+			return rel
+		}
+	}
+	return nil
+}
+
+// columns resolves the relation to the column of t holding the key
+// values and the column of the related table they are matched with.
+func (r *RelationMap) columns() (related *TableMap, local, remote *ColumnMap, err error) {
+	related, err = r.table.dbmap.TableFor(r.relType, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// owner holds the foreign key, target is referenced by it
+	owner, target := related, r.table
+	if r.kind == belongsTo {
+		owner, target = r.table, related
+	}
+
+	var fkCol, refCol *ColumnMap
+	if r.ForeignKey != "" {
+		fkCol = colMapOrNil(owner, r.ForeignKey)
+	} else {
+		for _, fk := range owner.ForeignKeys() {
+			if fk.refTableMap() == target && len(fk.columns) == 1 {
+				fkCol = fk.columns[0]
+				refCol = colMapOrNil(target, fk.refColumnNames()[0])
+				break
+			}
+		}
+	}
+	if r.References != "" {
+		refCol = colMapOrNil(target, r.References)
+	} else if refCol == nil && len(target.keys) == 1 {
+		refCol = target.keys[0]
+	}
+	if fkCol == nil || refCol == nil {
+		return nil, nil, nil, fmt.Errorf("gorp: cannot resolve the keys of relation %s.%s",
+			r.table.TableName, r.FieldName)
+	}
+
+	if r.kind == belongsTo {
+		return related, fkCol, refCol, nil
+	}
+	return related, refCol, fkCol, nil
+}
+
+// relationKey returns a comparable form of a key value, so that keys of
+// different integer types match, or nil for NULL keys.
+func relationKey(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	i := v.Interface()
+	if valuer, ok := i.(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		if err != nil || dv == nil {
+			return nil
+		}
+		v = reflect.ValueOf(dv)
+		i = dv
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return i
+}
+
+// maxPreloadKeys is the largest number of keys bound in a single preload
+// query, as Oracle rejects longer "in" lists.
+const maxPreloadKeys = 1000
+
+// preload loads the relations into the structs of list.
+func preload(m *DbMap, exec SqlExecutor, list interface{}, relations []string) error {
+	rows, t, err := preloadTargets(list)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	table, err := m.TableFor(t, false)
+	if err != nil {
+		return err
+	}
+
+	// group nested relations by their first component
+	var names []string
+	nested := make(map[string][]string)
+	for _, path := range relations {
+		parts := strings.SplitN(path, ".", 2)
+		if _, ok := nested[parts[0]]; !ok {
+			names = append(names, parts[0])
+			nested[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+
+	for _, name := range names {
+		rel := table.Relation(name)
+		if rel == nil {
+			return fmt.Errorf("gorp: no relation %s on table %s", name, table.TableName)
+		}
+		loaded, err := rel.load(exec, rows)
+		if err != nil {
+			return err
+		}
+		if len(nested[name]) > 0 && len(loaded) > 0 {
+			err = preload(m, exec, loaded, nested[name])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// preloadTargets returns the addressable structs in list, and their type.
+func preloadTargets(list interface{}) ([]reflect.Value, reflect.Type, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	var values []reflect.Value
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, v.Index(i))
+		}
+	} else {
+		values = []reflect.Value{v}
+	}
+
+	var t reflect.Type
+	rows := make([]reflect.Value, 0, len(values))
+	for _, e := range values {
+		for e.Kind() == reflect.Interface || e.Kind() == reflect.Ptr {
+			e = e.Elem()
+		}
+		if !e.IsValid() {
+			// nil elements have nothing to load
+			continue
+		}
+		if e.Kind() != reflect.Struct || !e.CanAddr() {
+			return nil, nil, fmt.Errorf("gorp: cannot preload into %v", e.Type())
+		}
+		if t == nil {
+			t = e.Type()
+		} else if e.Type() != t {
+			return nil, nil, errors.New("gorp: cannot preload into mixed types")
+		}
+		rows = append(rows, e)
+	}
+	return rows, t, nil
+}
+
+// load runs one query for the related rows of all rows and assigns them,
+// returning the related rows loaded.
+func (r *RelationMap) load(exec SqlExecutor, rows []reflect.Value) ([]interface{}, error) {
+	related, local, remote, err := r.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []interface{}
+	seen := make(map[interface{}]bool)
+	for _, row := range rows {
+		v := row.FieldByName(local.fieldName)
+		key := relationKey(v)
+		if key == nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		keys = append(keys, v.Interface())
+	}
+
+	var loaded []interface{}
+	for start := 0; start < len(keys); start += maxPreloadKeys {
+		end := start + maxPreloadKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		quoted := related.dbmap.Dialect.QuoteField(remote.ColumnName)
+		list, err := exec.Query(reflect.New(related.gotype).Interface()).
+			Where(quoted+" in (?)", keys[start:end]).
+			Select(nil)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, list...)
+	}
+
+	byKey := make(map[interface{}][]reflect.Value)
+	for _, l := range loaded {
+		v := reflect.ValueOf(l)
+		key := relationKey(v.Elem().FieldByName(remote.fieldName))
+		byKey[key] = append(byKey[key], v)
+	}
+
+	for _, row := range rows {
+		field := row.FieldByName(r.FieldName)
+		matches := byKey[relationKey(row.FieldByName(local.fieldName))]
+		if r.kind == hasMany {
+			list := reflect.MakeSlice(field.Type(), 0, len(matches))
+			for _, match := range matches {
+				if field.Type().Elem().Kind() != reflect.Ptr {
+					match = match.Elem()
+				}
+				list = reflect.Append(list, match)
+			}
+			field.Set(list)
+			continue
+		}
+		field.Set(reflect.Zero(field.Type()))
+		if len(matches) > 0 {
+			match := matches[0]
+			if field.Kind() != reflect.Ptr {
+				match = match.Elem()
+			}
+			field.Set(match)
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,90 @@
+package gorp
+
+import (
+	"database/sql"
+	"sync/atomic"
+)
+
+// Balancer picks the replica a read statement is sent to.  Pick is called
+// concurrently with the replicas given to SetReplicas, and may return nil
+// to send the statement to the primary instead.
+type Balancer interface {
+	Pick(replicas []*sql.DB) *sql.DB
+}
+
+// The BalancerFunc type is an adapter to allow the use of ordinary
+// functions as a Balancer.
+type BalancerFunc func(replicas []*sql.DB) *sql.DB
+
+// Pick calls f(replicas).
+func (f BalancerFunc) Pick(replicas []*sql.DB) *sql.DB {
+	return f(replicas)
+}
+
+// RoundRobinBalancer sends read statements to each replica in turn.  The
+// zero value is ready to use.
+type RoundRobinBalancer struct {
+	next uint64
+}
+
+// Pick implements Balancer.
+func (b *RoundRobinBalancer) Pick(replicas []*sql.DB) *sql.DB {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return replicas[n%uint64(len(replicas))]
+}
+
+// SetReplicas sends the statements of Select, SelectOne, Get, Query and
+// the SelectInt family run through the DbMap to one of replicas, picked
+// by balancer.  Writes, statements run in a Transaction and the reads
+// gorp runs as part of a write keep going to Db, the primary.  A nil
+// balancer is a RoundRobinBalancer; no replicas turn read splitting off.
+//
+// Replicas lag behind the primary, so use Primary to read rows that were
+// just written:
+//
+//	dbmap.SetReplicas(nil, replica1, replica2)
+//	err := dbmap.Insert(inv)
+//	obj, err := dbmap.Primary().Get(Invoice{}, inv.Id)
+//
+// Set the replicas before using the DbMap; they are not safe to change
+// concurrently with statements.
+func (m *DbMap) SetReplicas(balancer Balancer, replicas ...*sql.DB) {
+	if balancer == nil {
+		balancer = &RoundRobinBalancer{}
+	}
+	m.replicas = append([]*sql.DB(nil), replicas...)
+	m.balancer = balancer
+}
+
+// Primary returns a shallow copy of the DbMap running all statements on
+// Db, ignoring the replicas set with SetReplicas.  Like the one of
+// WithContext, the copy is a snapshot of the configuration of m.
+func (m *DbMap) Primary() SqlExecutor {
+	dup := &DbMap{}
+	*dup = *m
+	dup.onPrimary = true
+	return dup
+}
+
+// onPrimary returns e, or a copy of it reading from the primary if it is
+// a DbMap with replicas.  Reads that must see the effect of a preceding
+// write use it.
+func onPrimary(e SqlExecutor) SqlExecutor {
+	if m, ok := e.(*DbMap); ok && len(m.replicas) > 0 && !m.onPrimary {
+		return m.Primary()
+	}
+	return e
+}
+
+// replica returns the replica to run stmt on, or nil if it must run on
+// the primary.  Only the statements of the read operations qualify.
+func (m *DbMap) replica(stmt *Statement) *sql.DB {
+	if len(m.replicas) == 0 || stmt.Kind == ExecStatement {
+		return nil
+	}
+	switch stmt.Operation {
+	case "", "get", "select":
+		return m.balancer.Pick(m.replicas)
+	}
+	return nil
+}
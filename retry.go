@@ -0,0 +1,151 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+)
+
+// TxOptions configures DbMap.InTransaction.  The zero value runs the
+// transaction with the default isolation level, up to DefaultTxAttempts
+// times with DefaultTxBackoff.
+type TxOptions struct {
+	// Isolation level and access mode of the transaction, as passed to
+	// BeginTx.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+
+	// MaxAttempts is the number of times the transaction is run before
+	// its error is returned.  1 disables retries.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the given retry, counting
+	// from 1.
+	Backoff func(retry int) time.Duration
+
+	// Retryable reports whether a transaction failing with err may
+	// succeed if run again.  If nil, the dialect decides if it is a
+	// RetryClassifier, and no error is retried otherwise.
+	Retryable func(err error) bool
+}
+
+// DefaultTxAttempts is the number of times InTransaction runs a
+// transaction, unless TxOptions.MaxAttempts is set.
+const DefaultTxAttempts = 3
+
+// DefaultTxBackoff waits 10ms before the first retry and doubles the wait
+// for each further retry, up to a second, with up to 50% random jitter.
+func DefaultTxBackoff(retry int) time.Duration {
+	d := time.Second
+	if retry < 8 {
+		d = 10 * time.Millisecond << uint(retry-1)
+	}
+	return d + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// PanicError is returned by InTransaction when its function panics.  The
+// transaction is rolled back and the panic is not retried.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("gorp: panic in transaction: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// InTransaction runs fn in a transaction, which is committed if fn
+// returns nil and rolled back otherwise.  If fn panics, the transaction
+// is rolled back and a *PanicError is returned.
+//
+// When beginning, running fn or committing fails with an error the
+// dialect classifies as a serialization failure or deadlock, the
+// transaction is rolled back and run again after a backoff, up to
+// opts.MaxAttempts times.  fn must therefore be safe to run several times.
+// opts may be nil.
+//
+//	err := dbmap.InTransaction(func(tx *gorp.Transaction) error {
+//		inv.Memo = "paid"
+//		_, err := tx.Update(inv)
+//		return err
+//	}, nil)
+func (m *DbMap) InTransaction(fn func(*Transaction) error, opts *TxOptions) error {
+	return m.InTransactionContext(m.Context(), fn, opts)
+}
+
+// InTransactionContext is like InTransaction, but begins the transaction
+// with BeginTx(ctx, ...).  No attempt is made once ctx is done.
+func (m *DbMap) InTransactionContext(ctx context.Context, fn func(*Transaction) error, opts *TxOptions) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+	backoff := opts.Backoff
+	if backoff == nil {
+		backoff = DefaultTxBackoff
+	}
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = m.IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.runTransaction(ctx, fn, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+		if _, panicked := err.(*PanicError); panicked || err == nil {
+			return err
+		}
+		if attempt >= attempts || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// IsRetryable reports whether the dialect classifies err as a failure of
+// a transaction that may succeed if run again.  See RetryClassifier.
+func (m *DbMap) IsRetryable(err error) bool {
+	if classifier, ok := m.Dialect.(RetryClassifier); ok && err != nil {
+		return classifier.IsRetryable(err)
+	}
+	return false
+}
+
+// runTransaction makes one attempt of InTransaction.
+func (m *DbMap) runTransaction(ctx context.Context, fn func(*Transaction) error, txOpts *sql.TxOptions) (err error) {
+	tx, err := m.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			stack := make([]byte, 64<<10)
+			err = &PanicError{Value: r, Stack: stack[:runtime.Stack(stack, false)]}
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
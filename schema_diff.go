@@ -0,0 +1,282 @@
+package gorp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaDifferenceKind identifies what a SchemaDifference is about.
+type SchemaDifferenceKind int
+
+const (
+	// DiffMissingTable means a mapped table does not exist.
+	DiffMissingTable SchemaDifferenceKind = iota
+	// DiffMissingColumn means a mapped column does not exist.
+	DiffMissingColumn
+	// DiffExtraColumn means the table has a column that is not mapped.
+	DiffExtraColumn
+	// DiffColumnType means a column has a different type than gorp
+	// would create it with.
+	DiffColumnType
+	// DiffColumnSize means a column has the expected type but a
+	// different size.
+	DiffColumnSize
+	// DiffColumnNullability means a column is nullable where it should
+	// be not null, or the other way around.
+	DiffColumnNullability
+	// DiffMissingIndex means an index added with TableMap.AddIndex does
+	// not exist.
+	DiffMissingIndex
+	// DiffMissingUnique means a unique column or a SetUniqueTogether
+	// constraint is not backed by a unique index or constraint.
+	DiffMissingUnique
+)
+
+var schemaDifferenceKinds = map[SchemaDifferenceKind]string{
+	DiffMissingTable:      "missing table",
+	DiffMissingColumn:     "missing column",
+	DiffExtraColumn:       "extra column",
+	DiffColumnType:        "column type mismatch",
+	DiffColumnSize:        "column size mismatch",
+	DiffColumnNullability: "column nullability mismatch",
+	DiffMissingIndex:      "missing index",
+	DiffMissingUnique:     "missing unique constraint",
+}
+
+func (k SchemaDifferenceKind) String() string {
+	if s, ok := schemaDifferenceKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("SchemaDifferenceKind(%d)", int(k))
+}
+
+// SchemaDifference describes one way in which the live database differs
+// from the registered TableMaps.
+type SchemaDifference struct {
+	Kind  SchemaDifferenceKind
+	Table *TableMap
+
+	// Name is the column, index or constraint the difference is about.
+	// It is empty for DiffMissingTable.
+	Name string
+
+	// Expected and Actual describe the mapped and the live definition,
+	// for example the column types for DiffColumnType.
+	Expected string
+	Actual   string
+
+	// Sql is the statement that reconciles the difference, or an empty
+	// string if the dialect cannot generate one.  Applying the
+	// statements in order brings the database in line with the mapping.
+	Sql string
+}
+
+func (d SchemaDifference) String() string {
+	s := fmt.Sprintf("%s: %s", d.Table.TableName, d.Kind)
+	if d.Name != "" {
+		s += " " + d.Name
+	}
+	if d.Expected != "" || d.Actual != "" {
+		s += fmt.Sprintf(" (expected %q, got %q)", d.Expected, d.Actual)
+	}
+	return s
+}
+
+// DiffSchema compares the registered tables with the connected database
+// and returns the differences, in the order of the registered tables.
+// An empty result means the database matches the mapping.
+//
+// The dialect must implement SchemaIntrospector.  Reconciling statements
+// are generated if it also implements ColumnAlterer.
+//
+// Column types are compared with the type Dialect.ToSqlType returns for
+// the field.  Columns whose type was changed by hand, for example to a
+// "text" column, are reported as DiffColumnType.
+func (m *DbMap) DiffSchema() ([]SchemaDifference, error) {
+	return m.diffSchema(m)
+}
+
+// DiffSchemaContext has the same behavior as DiffSchema, but runs with ctx.
+func (m *DbMap) DiffSchemaContext(ctx context.Context) ([]SchemaDifference, error) {
+	return m.diffSchema(m.WithContext(ctx))
+}
+
+func (m *DbMap) diffSchema(exec SqlExecutor) ([]SchemaDifference, error) {
+	introspector, ok := m.Dialect.(SchemaIntrospector)
+	if !ok {
+		return nil, errors.New("gorp: dialect does not support schema introspection")
+	}
+
+	var diffs []SchemaDifference
+	seen := make(map[string]bool)
+	for _, table := range m.tables {
+		// several types may be mapped to the same table
+		name := table.SchemaName + "." + table.TableName
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		cols, err := introspector.Columns(exec, table.SchemaName, table.TableName)
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := introspector.Indexes(exec, table.SchemaName, table.TableName)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, table.diffSchema(cols, indexes)...)
+	}
+	return diffs, nil
+}
+
+// diffSchema compares the table with its live columns and indexes.
+func (t *TableMap) diffSchema(cols []ColumnInfo, indexes []IndexInfo) []SchemaDifference {
+	dialect := t.dbmap.Dialect
+	alterer, _ := dialect.(ColumnAlterer)
+	quotedTable := dialect.QuotedTableForQuery(t.SchemaName, t.TableName)
+
+	var diffs []SchemaDifference
+	add := func(kind SchemaDifferenceKind, name, expected, actual string, sql func(ColumnAlterer) string) {
+		d := SchemaDifference{Kind: kind, Table: t, Name: name, Expected: expected, Actual: actual}
+		if alterer != nil && sql != nil {
+			d.Sql = sql(alterer)
+		}
+		diffs = append(diffs, d)
+	}
+
+	if len(cols) == 0 {
+		diffs = append(diffs, SchemaDifference{Kind: DiffMissingTable, Table: t, Sql: t.SqlForCreate(false)})
+	} else {
+		actual := make(map[string]ColumnInfo, len(cols))
+		for _, col := range cols {
+			actual[strings.ToLower(col.Name)] = col
+		}
+		mapped := make(map[string]bool)
+		for _, col := range t.Columns {
+			if col.Transient {
+				continue
+			}
+			mapped[strings.ToLower(col.ColumnName)] = true
+			quotedCol := dialect.QuoteField(col.ColumnName)
+			info, ok := actual[strings.ToLower(col.ColumnName)]
+			if !ok {
+				def := t.sqlForColumn(col)
+				add(DiffMissingColumn, col.ColumnName, def, "", func(a ColumnAlterer) string {
+					return a.AddColumn(quotedTable, def)
+				})
+				continue
+			}
+
+			sqlType := col.sqlType(dialect)
+			notNull := col.isPK || col.isNotNull
+			alter := func(a ColumnAlterer) string {
+				return a.AlterColumn(quotedTable, quotedCol, sqlType, notNull)
+			}
+			expectedBase, expectedSize := parseSqlType(normalizeSqlType(sqlType))
+			actualBase, actualSize := parseSqlType(normalizeSqlType(info.Type))
+			typeMatches := false
+			switch {
+			case expectedBase != actualBase:
+				add(DiffColumnType, col.ColumnName, sqlType, info.Type, alter)
+			case expectedSize != actualSize:
+				add(DiffColumnSize, col.ColumnName, sqlType, info.Type, alter)
+			default:
+				typeMatches = true
+			}
+			if notNull == info.Nullable {
+				// the statement fixing the type also fixes nullability
+				if !typeMatches {
+					alter = nil
+				}
+				add(DiffColumnNullability, col.ColumnName, nullability(!notNull), nullability(info.Nullable), alter)
+			}
+		}
+		for _, col := range cols {
+			if !mapped[strings.ToLower(col.Name)] {
+				quotedCol := dialect.QuoteField(col.Name)
+				add(DiffExtraColumn, col.Name, "", col.Type, func(a ColumnAlterer) string {
+					return a.DropColumn(quotedTable, quotedCol)
+				})
+			}
+		}
+	}
+
+	for _, index := range t.indexes {
+		if hasIndex(indexes, index.IndexName, index.columns, index.Unique) {
+			continue
+		}
+		sql := t.sqlForCreateIndex(index)
+		diffs = append(diffs, SchemaDifference{
+			Kind:     DiffMissingIndex,
+			Table:    t,
+			Name:     index.IndexName,
+			Expected: strings.Join(index.columns, ", "),
+			Sql:      sql,
+		})
+	}
+
+	if len(cols) == 0 {
+		// unique constraints are part of the create table statement
+		return diffs
+	}
+	var uniques [][]string
+	for _, col := range t.Columns {
+		if col.Unique && !col.Transient {
+			uniques = append(uniques, []string{col.ColumnName})
+		}
+	}
+	uniques = append(uniques, t.uniqueTogether...)
+	for _, columns := range uniques {
+		if hasIndex(indexes, "", columns, true) {
+			continue
+		}
+		name := fmt.Sprintf("%s_%s_key", t.TableName, strings.Join(columns, "_"))
+		quotedCols := make([]string, len(columns))
+		for i, col := range columns {
+			quotedCols[i] = dialect.QuoteField(col)
+		}
+		add(DiffMissingUnique, name, strings.Join(columns, ", "), "", func(a ColumnAlterer) string {
+			return a.AddUnique(quotedTable, name, quotedCols)
+		})
+	}
+	return diffs
+}
+
+// hasIndex reports whether indexes contains an index named name, or one
+// over the given columns in any order.  If unique is set, only unique
+// indexes are considered.
+func hasIndex(indexes []IndexInfo, name string, columns []string, unique bool) bool {
+	want := sortedLower(columns)
+	for _, index := range indexes {
+		if unique && !index.Unique {
+			continue
+		}
+		if name != "" && strings.EqualFold(index.Name, name) {
+			return true
+		}
+		if strings.Join(sortedLower(index.Columns), ",") == strings.Join(want, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedLower(list []string) []string {
+	sorted := make([]string, len(list))
+	for i, s := range list {
+		sorted[i] = strings.ToLower(s)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "null"
+	}
+	return "not null"
+}
@@ -237,7 +237,7 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	}
 
 	// Run the query
-	rows, err := exec.query(query, args...)
+	rows, err := withTable(exec, tableOrNil(m, t), "select", nil).query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -249,23 +249,14 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 		return nil, err
 	}
 
-	if !intoStruct && len(cols) > 1 {
-		return nil, fmt.Errorf("gorp: select into non-struct slice requires 1 column, got %d", len(cols))
-	}
-
-	var colToFieldIndex [][]int
-	if intoStruct {
-		colToFieldIndex, err = columnToFieldIndex(m, t, cols)
-		if err != nil {
-			if !NonFatalError(err) {
-				return nil, err
-			}
-			nonFatalErr = err
+	scanner, err := newRowScanner(m, t, intoStruct, cols)
+	if err != nil {
+		if !NonFatalError(err) {
+			return nil, err
 		}
+		nonFatalErr = err
 	}
 
-	conv := m.TypeConverter
-
 	// Add results to one of these two slices.
 	var (
 		list       = make([]interface{}, 0)
@@ -275,53 +266,18 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 	for {
 		if !rows.Next() {
 			// if error occured return rawselect
-			if rows.Err() != nil {
-				return nil, rows.Err()
+			if err := rows.Err(); err != nil {
+				return nil, m.classifyError(err)
 			}
 			// time to exit from outer "for" loop
 			break
 		}
 		v := reflect.New(t)
-		dest := make([]interface{}, len(cols))
-
-		custScan := make([]CustomScanner, 0)
-
-		for x := range cols {
-			f := v.Elem()
-			if intoStruct {
-				index := colToFieldIndex[x]
-				if index == nil {
-					// this field is not present in the struct, so create a dummy
-					// value for rows.Scan to scan into
-					var dummy dummyField
-					dest[x] = &dummy
-					continue
-				}
-				f = f.FieldByIndex(index)
-			}
-			target := f.Addr().Interface()
-			if conv != nil {
-				scanner, ok := conv.FromDb(target)
-				if ok {
-					target = scanner.Holder
-					custScan = append(custScan, scanner)
-				}
-			}
-			dest[x] = target
-		}
-
-		err = rows.Scan(dest...)
+		err = scanner.scan(rows, v)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, c := range custScan {
-			err = c.Bind()
-			if err != nil {
-				return nil, err
-			}
-		}
-
 		if appendToSlice {
 			if !pointerElements {
 				v = v.Elem()
@@ -338,3 +294,172 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 
 	return list, nonFatalErr
 }
+
+// SelectIterator streams the results of a query, decoding one row at a
+// time instead of loading the whole result set into memory.  It is
+// used like sql.Rows:
+//
+//	iter, err := dbmap.SelectIter(Invoice{}, "select * from invoice_test")
+//	if err != nil {
+//		return err
+//	}
+//	defer iter.Close()
+//	for iter.Next() {
+//		var inv Invoice
+//		if err := iter.Scan(&inv); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//	return iter.Err()
+//
+// The iterator holds a database connection until it is closed or Next
+// returns false.
+type SelectIterator struct {
+	dbmap   *DbMap
+	exec    SqlExecutor
+	rows    *sql.Rows
+	scanner *rowScanner
+}
+
+func selectIter(m *DbMap, exec SqlExecutor, i interface{}, query string,
+	args ...interface{}) (*SelectIterator, error) {
+
+	t := reflect.TypeOf(i)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if len(args) == 1 {
+		query, args = maybeExpandNamedQuery(m, query, args)
+	}
+
+	rows, err := withTable(exec, tableOrNil(m, t), "select", nil).query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	scanner, err := newRowScanner(m, t, t.Kind() == reflect.Struct, cols)
+	if err != nil && !NonFatalError(err) {
+		rows.Close()
+		return nil, err
+	}
+	return &SelectIterator{dbmap: m, exec: exec, rows: rows, scanner: scanner}, err
+}
+
+// Next advances to the next row, returning false when there are no more
+// rows or an error occurred.  Check Err to tell the two apart.
+func (iter *SelectIterator) Next() bool {
+	return iter.rows.Next()
+}
+
+// Scan decodes the current row into dest, which must be a pointer to the
+// type SelectIter was called with.  Columns are matched to fields as in
+// Select, and the PostGet hook of dest is run afterwards.
+//
+// PostGet hooks run while the query is still open.  Hooks that run
+// queries of their own need a connection besides the iterator's, so they
+// will fail or block inside a transaction on most databases.
+func (iter *SelectIterator) Scan(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != iter.scanner.t {
+		return fmt.Errorf("gorp: Scan requires a pointer to %v, got %T", iter.scanner.t, dest)
+	}
+	v.Elem().Set(reflect.Zero(iter.scanner.t))
+	err := iter.scanner.scan(iter.rows, v)
+	if err != nil {
+		return err
+	}
+	if v, ok := dest.(HasPostGet); ok {
+		return v.PostGet(iter.exec)
+	}
+	return nil
+}
+
+// Err returns the error, if any, that ended the iteration.
+func (iter *SelectIterator) Err() error {
+	return iter.dbmap.classifyError(iter.rows.Err())
+}
+
+// Close closes the underlying rows.  It is safe to call Close more than
+// once, and after Next has returned false.
+func (iter *SelectIterator) Close() error {
+	return iter.rows.Close()
+}
+
+// rowScanner decodes rows into values of a single type, using the
+// TypeConverter of the DbMap.
+type rowScanner struct {
+	t               reflect.Type
+	intoStruct      bool
+	cols            []string
+	colToFieldIndex [][]int
+	conv            TypeConverter
+}
+
+// newRowScanner returns a rowScanner decoding rows with the columns cols
+// into values of type t.  Like columnToFieldIndex, it returns a non-fatal
+// error along with the scanner if some columns have no matching field.
+func newRowScanner(m *DbMap, t reflect.Type, intoStruct bool, cols []string) (*rowScanner, error) {
+	if !intoStruct && len(cols) > 1 {
+		return nil, fmt.Errorf("gorp: select into non-struct slice requires 1 column, got %d", len(cols))
+	}
+	s := &rowScanner{t: t, intoStruct: intoStruct, cols: cols, conv: m.TypeConverter}
+	var err error
+	if intoStruct {
+		s.colToFieldIndex, err = columnToFieldIndex(m, t, cols)
+		if err != nil && !NonFatalError(err) {
+			return nil, err
+		}
+	}
+	return s, err
+}
+
+// scan decodes the current row of rows into v, a pointer to a value of
+// the scanner's type.
+func (s *rowScanner) scan(rows *sql.Rows, v reflect.Value) error {
+	dest := make([]interface{}, len(s.cols))
+
+	custScan := make([]CustomScanner, 0)
+
+	for x := range s.cols {
+		f := v.Elem()
+		if s.intoStruct {
+			index := s.colToFieldIndex[x]
+			if index == nil {
+				// this field is not present in the struct, so create a dummy
+				// value for rows.Scan to scan into
+				var dummy dummyField
+				dest[x] = &dummy
+				continue
+			}
+			f = f.FieldByIndex(index)
+		}
+		target := f.Addr().Interface()
+		if s.conv != nil {
+			scanner, ok := s.conv.FromDb(target)
+			if ok {
+				target = scanner.Holder
+				custScan = append(custScan, scanner)
+			}
+		}
+		dest[x] = target
+	}
+
+	err := rows.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range custScan {
+		err = c.Bind()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package gorp
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	nullTimeType = reflect.TypeOf(NullTime{})
+	sqlNullTime  = reflect.TypeOf(sql.NullTime{})
+)
+
+// SetSoftDeleteCol makes field the soft delete marker of the table.  The
+// field must be a bool, which is true for deleted rows, or a *time.Time,
+// NullTime or sql.NullTime, which holds the time of deletion, as told by
+// the DbMap's Clock, and is NULL for rows that are not deleted.
+//
+// With a soft delete marker, Delete sets the marker with an UPDATE
+// instead of deleting the row, and Get and Query skip deleted rows.
+// HardDelete deletes rows for good, Restore clears the marker, and
+// WithDeleted returns an executor whose Get and Query include deleted
+// rows.  Queries run with Select are not changed.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+//
+// Panics if the struct does not contain a field matching this name, or
+// if the field has another type.
+func (t *TableMap) SetSoftDeleteCol(field string) *ColumnMap {
+	t.checkFrozen("SetSoftDeleteCol")
+	c := t.ColMap(field)
+	f, _ := t.gotype.FieldByName(c.fieldName)
+	switch f.Type {
+	case reflect.TypeOf(false), reflect.PtrTo(timeType), nullTimeType, sqlNullTime:
+	default:
+		panic(fmt.Sprintf("gorp: soft delete field %s must be a bool, *time.Time, NullTime or sql.NullTime, not %v",
+			field, f.Type))
+	}
+	t.softDelete = c
+	t.ResetSql()
+	return c
+}
+
+// SoftDeleteCol returns the soft delete marker of the table, or nil if
+// rows are deleted for good.
+func (t *TableMap) SoftDeleteCol() *ColumnMap {
+	return t.softDelete
+}
+
+// softDeleteMarker returns the value of the soft delete field of a
+// deleted row, or of a row that is not deleted.
+func (t *TableMap) softDeleteMarker(deleted bool) reflect.Value {
+	f, _ := t.gotype.FieldByName(t.softDelete.fieldName)
+	now := t.dbmap.now()
+	switch f.Type {
+	case nullTimeType:
+		return reflect.ValueOf(NullTime{Time: now, Valid: deleted})
+	case sqlNullTime:
+		return reflect.ValueOf(sql.NullTime{Time: now, Valid: deleted})
+	case reflect.TypeOf(false):
+		return reflect.ValueOf(deleted)
+	}
+	if !deleted {
+		return reflect.Zero(f.Type)
+	}
+	return reflect.ValueOf(&now)
+}
+
+// notDeletedCond returns the condition selecting the rows that are not
+// soft deleted and its arguments, using bindVar for the argument of a
+// bool marker.
+func (t *TableMap) notDeletedCond(bindVar string) (string, []interface{}) {
+	col := t.dbmap.Dialect.QuoteField(t.softDelete.ColumnName)
+	if t.softDelete.gotype.Kind() == reflect.Bool {
+		return col + "=" + bindVar, []interface{}{false}
+	}
+	return col + " is null", nil
+}
+
+// bindSoftDelete binds the statement setting the soft delete marker of
+// elem to marker.  The statement only matches rows that are not deleted
+// if deleted is true, and increments the version column like an update.
+func (t *TableMap) bindSoftDelete(elem reflect.Value, marker reflect.Value, deleted bool) (bindInstance, error) {
+	plan := t.softDeleteBindPlan(deleted)
+	bi, err := plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	if err != nil {
+		return bindInstance{}, err
+	}
+	// The marker is bound in place of the field, which is only set once
+	// the statement succeeds.
+	val := marker.Interface()
+	if conv := t.dbmap.TypeConverter; conv != nil {
+		val, err = conv.ToDb(val)
+		if err != nil {
+			return bindInstance{}, err
+		}
+	}
+	bi.args[0] = val
+	return bi, nil
+}
+
+func (t *TableMap) softDeleteBindPlan(deleted bool) bindPlan {
+	plan := t.restorePlan.load()
+	if deleted {
+		plan = t.softDeletePlan.load()
+	}
+	if plan.query == "" {
+		dialect := t.dbmap.Dialect
+		s := bytes.Buffer{}
+		s.WriteString(fmt.Sprintf("update %s set ", dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+		s.WriteString(dialect.QuoteField(t.softDelete.ColumnName) + "=" + dialect.BindVar(0))
+		plan.argFields = append(plan.argFields, t.softDelete.fieldName)
+		if t.version != nil {
+			plan.versField = t.version.fieldName
+			s.WriteString(", " + dialect.QuoteField(t.version.ColumnName) + "=" + dialect.BindVar(1))
+			plan.argFields = append(plan.argFields, versFieldConst)
+		}
+
+		s.WriteString(" where ")
+		for x, col := range t.keys {
+			if x > 0 {
+				s.WriteString(" and ")
+			}
+			s.WriteString(dialect.QuoteField(col.ColumnName) + "=" + dialect.BindVar(len(plan.argFields)))
+			plan.argFields = append(plan.argFields, col.fieldName)
+			plan.keyFields = append(plan.keyFields, col.fieldName)
+		}
+		if plan.versField != "" {
+			s.WriteString(" and " + dialect.QuoteField(t.version.ColumnName) + "=" + dialect.BindVar(len(plan.argFields)))
+			plan.argFields = append(plan.argFields, plan.versField)
+		}
+		if deleted {
+			cond, args := t.notDeletedCond(dialect.BindVar(len(plan.argFields)))
+			s.WriteString(" and " + cond)
+			plan.condArgs = args
+		}
+		s.WriteString(dialect.QuerySuffix())
+
+		plan.query = s.String()
+		plan.sensitive = t.sensitiveArgs(plan.argFields)
+		if deleted {
+			t.softDeletePlan.store(plan)
+		} else {
+			t.restorePlan.store(plan)
+		}
+	}
+
+	return plan
+}
+
+// includeDeleted reports whether Get and Query return soft deleted rows
+// when run with e.
+func includeDeleted(e SqlExecutor) bool {
+	switch m := e.(type) {
+	case *DbMap:
+		return m.withDeleted
+	case *Transaction:
+		return m.withDeleted
+	}
+	return false
+}
+
+// restore clears the soft delete marker of the rows in list.
+func restore(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	count := int64(0)
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, true)
+		if err != nil {
+			return -1, err
+		}
+		if table.softDelete == nil {
+			return -1, fmt.Errorf("gorp: table %s has no soft delete column", table.TableName)
+		}
+
+		marker := table.softDeleteMarker(false)
+		bi, err := table.bindSoftDelete(elem, marker, false)
+		if err != nil {
+			return -1, err
+		}
+		rows, err := execSoftDelete(m, exec, table, elem, bi, marker, false)
+		if err != nil {
+			return -1, err
+		}
+		count += rows
+	}
+	return count, nil
+}
+
+// execSoftDelete runs a statement bound by bindSoftDelete, and sets the
+// marker and version fields of elem if it succeeds.
+func execSoftDelete(m *DbMap, exec SqlExecutor, table *TableMap, elem reflect.Value, bi bindInstance, marker reflect.Value, deleted bool) (int64, error) {
+	op := "restore"
+	if deleted {
+		op = "delete"
+	}
+	res, err := withTable(exec, table, op, bi.sensitive).Exec(bi.query, bi.args...)
+	if err != nil {
+		return -1, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	if rows == 0 && bi.existingVersion > 0 {
+		if !deleted {
+			// the row to restore is expected to be deleted
+			exec = exec.WithDeleted()
+		}
+		return lockError(m, exec, table.TableName,
+			bi.existingVersion, elem, bi.keys...)
+	}
+
+	if rows > 0 {
+		elem.FieldByName(table.softDelete.fieldName).Set(marker)
+		if bi.versField != "" {
+			elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+		}
+	}
+	return rows, nil
+}
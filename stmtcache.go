@@ -0,0 +1,180 @@
+package gorp
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// SetStmtCache turns on a cache of up to size prepared statements for the
+// statements gorp generates for Insert, Update, Delete, Get, Upsert and
+// the like.  These are then prepared once and reused, instead of sending
+// their SQL to the database every time.  Statements run in a Transaction
+// use the cached statement rebound to the transaction with sql.Tx.Stmt.
+// Queries written by hand are never cached; use Prepare for those.
+//
+// When the cache is full, the least recently used statement is closed.
+// A size of 0 turns the cache off, closing the cached statements.  Set
+// the cache before using the DbMap; it is not safe to change it
+// concurrently with statements.
+func (m *DbMap) SetStmtCache(size int) {
+	if m.stmtCache != nil {
+		m.stmtCache.close()
+		m.stmtCache = nil
+	}
+	if size > 0 {
+		m.stmtCache = &stmtCache{db: m.Db, size: size, lru: list.New(), stmts: make(map[string]*list.Element)}
+	}
+}
+
+// stmtCache is a LRU cache of prepared statements, keyed by query.
+type stmtCache struct {
+	db   *sql.DB
+	size int
+
+	mu    sync.Mutex
+	lru   *list.List // of *cachedStmt, most recently used first
+	stmts map[string]*list.Element
+
+	// Number of evicted statements left as nil entries in stmts, since
+	// the builtin delete is shadowed in this package.
+	evicted int
+}
+
+type cachedStmt struct {
+	query string
+	stmt  *sql.Stmt
+
+	// Number of statements using stmt, which is only closed once it is
+	// evicted and no longer used.
+	users   int
+	evicted bool
+}
+
+// prepare returns the cached statement of query, preparing it if needed.
+// The statement must be released once it has been run.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*cachedStmt, error) {
+	c.mu.Lock()
+	if e := c.stmts[query]; e != nil {
+		c.lru.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.users++
+		c.mu.Unlock()
+		return cs, nil
+	}
+	c.mu.Unlock()
+
+	// Prepare without holding the lock, so that statements that are
+	// already cached are not held up by a slow prepare.
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.stmts[query]; e != nil {
+		// Prepared concurrently by another statement.
+		go stmt.Close()
+		c.lru.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.users++
+		return cs, nil
+	}
+	cs := &cachedStmt{query: query, stmt: stmt, users: 1}
+	c.stmts[query] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.size {
+		evicted := c.lru.Remove(c.lru.Back()).(*cachedStmt)
+		c.stmts[evicted.query] = nil
+		c.evicted++
+		c.evict(evicted)
+	}
+	if c.evicted > c.size {
+		c.stmts = make(map[string]*list.Element, c.lru.Len())
+		for e := c.lru.Front(); e != nil; e = e.Next() {
+			c.stmts[e.Value.(*cachedStmt).query] = e
+		}
+		c.evicted = 0
+	}
+	return cs, nil
+}
+
+// release ends a use of cs returned by prepare.
+func (c *stmtCache) release(cs *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs.users--
+	if cs.evicted && cs.users == 0 {
+		go cs.stmt.Close()
+	}
+}
+
+// evict closes cs once it is no longer used.  Close waits for the rows
+// of the statement to be closed, which may be held by the caller, so it
+// is never waited for.
+func (c *stmtCache) evict(cs *cachedStmt) {
+	cs.evicted = true
+	if cs.users == 0 {
+		go cs.stmt.Close()
+	}
+}
+
+// close closes all cached statements.
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		c.evict(e.Value.(*cachedStmt))
+	}
+	c.lru.Init()
+	c.stmts = make(map[string]*list.Element)
+	c.evicted = 0
+}
+
+// stmtExecutor runs its prepared statement in place of the queries it is
+// given.
+type stmtExecutor struct {
+	stmt *sql.Stmt
+}
+
+func (s stmtExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.stmt.ExecContext(ctx, args...)
+}
+
+func (s stmtExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.stmt.QueryContext(ctx, args...)
+}
+
+func (s stmtExecutor) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.stmt.QueryRowContext(ctx, args...)
+}
+
+// cachedExecutor returns the executor running stmt on conn through the
+// statement cache, or conn itself if the statement is not cached, and the
+// function to call once the statement has been run.
+func (m *DbMap) cachedExecutor(conn executor, stmt *Statement) (executor, func(), error) {
+	c := m.stmtCache
+	if c == nil || stmt.Table == nil || stmt.Operation == "select" {
+		return conn, func() {}, nil
+	}
+	if db, ok := conn.(*sql.DB); ok && db != c.db {
+		// Replicas have no cached statements.
+		return conn, func() {}, nil
+	}
+	if _, ok := conn.(*sql.Conn); ok {
+		// Statements prepared on the pool cannot be bound to the
+		// connection of a transaction begun by the dialect.
+		return conn, func() {}, nil
+	}
+	cs, err := c.prepare(stmt.Context, stmt.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	prepared := cs.stmt
+	if tx, ok := conn.(*sql.Tx); ok {
+		// The transaction closes the rebound statement when it ends.
+		prepared = tx.StmtContext(stmt.Context, prepared)
+	}
+	return stmtExecutor{prepared}, func() { c.release(cs) }, nil
+}
@@ -17,12 +17,21 @@ type TableMap struct {
 	Columns        []*ColumnMap
 	keys           []*ColumnMap
 	indexes        []*IndexMap
+	foreignKeys    []*ForeignKeyMap
+	relations      []*RelationMap
 	uniqueTogether [][]string
 	version        *ColumnMap
-	insertPlan     bindPlan
-	updatePlan     bindPlan
-	deletePlan     bindPlan
-	getPlan        bindPlan
+	softDelete     *ColumnMap
+	created        *ColumnMap
+	updated        *ColumnMap
+	insertPlan     planCache
+	updatePlan     planCache
+	deletePlan     planCache
+	softDeletePlan planCache
+	restorePlan    planCache
+	getPlan        planCache
+	getAllPlan     planCache
+	upsertPlan     planCache
 	dbmap          *DbMap
 }
 
@@ -30,10 +39,15 @@ type TableMap struct {
 // associated with this TableMap.  Call this if you've modified
 // any column names or the table name itself.
 func (t *TableMap) ResetSql() {
-	t.insertPlan = bindPlan{}
-	t.updatePlan = bindPlan{}
-	t.deletePlan = bindPlan{}
-	t.getPlan = bindPlan{}
+	t.checkFrozen("ResetSql")
+	t.insertPlan.reset()
+	t.updatePlan.reset()
+	t.deletePlan.reset()
+	t.softDeletePlan.reset()
+	t.restorePlan.reset()
+	t.getPlan.reset()
+	t.getAllPlan.reset()
+	t.upsertPlan.reset()
 }
 
 // SetKeys lets you specify the fields on a struct that map to primary
@@ -45,6 +59,7 @@ func (t *TableMap) ResetSql() {
 // Panics if isAutoIncr is true, and fieldNames length != 1
 //
 func (t *TableMap) SetKeys(isAutoIncr bool, fieldNames ...string) *TableMap {
+	t.checkFrozen("SetKeys")
 	if isAutoIncr && len(fieldNames) != 1 {
 		panic(fmt.Sprintf(
 			"gorp: SetKeys: fieldNames length must be 1 if key is auto-increment. (Saw %v fieldNames)",
@@ -71,6 +86,7 @@ func (t *TableMap) SetKeys(isAutoIncr bool, fieldNames ...string) *TableMap {
 // Panics if fieldNames length < 2.
 //
 func (t *TableMap) SetUniqueTogether(fieldNames ...string) *TableMap {
+	t.checkFrozen("SetUniqueTogether")
 	if len(fieldNames) < 2 {
 		panic(fmt.Sprintf(
 			"gorp: SetUniqueTogether: must provide at least two fieldNames to set uniqueness constraint."))
@@ -127,6 +143,7 @@ func (t *TableMap) IdxMap(field string) *IndexMap {
 // Automatically calls ResetSql() to ensure SQL statements are regenerated.
 //
 func (t *TableMap) AddIndex(name string, idxtype string, columns []string) *IndexMap {
+	t.checkFrozen("AddIndex")
 	// check if we have a index with this name already
 	for _, idx := range t.indexes {
 		if idx.IndexName == name {
@@ -152,6 +169,7 @@ func (t *TableMap) AddIndex(name string, idxtype string, columns []string) *Inde
 //
 // Automatically calls ResetSql() to ensure SQL statements are regenerated.
 func (t *TableMap) SetVersionCol(field string) *ColumnMap {
+	t.checkFrozen("SetVersionCol")
 	c := t.ColMap(field)
 	t.version = c
 	t.ResetSql()
@@ -161,6 +179,11 @@ func (t *TableMap) SetVersionCol(field string) *ColumnMap {
 // SqlForCreateTable gets a sequence of SQL commands that will create
 // the specified table and any associated schema
 func (t *TableMap) SqlForCreate(ifNotExists bool) string {
+	return t.sqlForCreate(ifNotExists, nil)
+}
+
+// sqlForCreate is SqlForCreate, leaving out the foreign keys in skip.
+func (t *TableMap) sqlForCreate(ifNotExists bool, skip map[*ForeignKeyMap]bool) string {
 	s := bytes.Buffer{}
 	dialect := t.dbmap.Dialect
 
@@ -188,22 +211,7 @@ func (t *TableMap) SqlForCreate(ifNotExists bool) string {
 			if x > 0 {
 				s.WriteString(", ")
 			}
-			stype := dialect.ToSqlType(col.gotype, col.MaxSize, col.isAutoIncr)
-			s.WriteString(fmt.Sprintf("%s %s", dialect.QuoteField(col.ColumnName), stype))
-
-			if col.isPK || col.isNotNull {
-				s.WriteString(" not null")
-			}
-			if col.isPK && len(t.keys) == 1 {
-				s.WriteString(" primary key")
-			}
-			if col.Unique {
-				s.WriteString(" unique")
-			}
-			if col.isAutoIncr {
-				s.WriteString(fmt.Sprintf(" %s", dialect.AutoIncrStr()))
-			}
-
+			s.WriteString(t.sqlForColumn(col))
 			x++
 		}
 	}
@@ -229,8 +237,89 @@ func (t *TableMap) SqlForCreate(ifNotExists bool) string {
 			s.WriteString(")")
 		}
 	}
+	for _, fk := range t.ForeignKeys() {
+		if skip[fk] {
+			continue
+		}
+		s.WriteString(", ")
+		s.WriteString(fk.sqlForConstraint())
+	}
 	s.WriteString(") ")
 	s.WriteString(dialect.CreateTableSuffix())
 	s.WriteString(dialect.QuerySuffix())
 	return s.String()
 }
+
+// sqlForColumn returns the definition of a column as written in
+// "create table".
+func (t *TableMap) sqlForColumn(col *ColumnMap) string {
+	s := bytes.Buffer{}
+	dialect := t.dbmap.Dialect
+	stype := col.sqlType(dialect)
+	s.WriteString(fmt.Sprintf("%s %s", dialect.QuoteField(col.ColumnName), stype))
+
+	if col.isPK || col.isNotNull {
+		s.WriteString(" not null")
+	}
+	if col.isPK && len(t.keys) == 1 {
+		s.WriteString(" primary key")
+	}
+	if col.Unique {
+		s.WriteString(" unique")
+	}
+	if col.isAutoIncr {
+		s.WriteString(fmt.Sprintf(" %s", dialect.AutoIncrStr()))
+	}
+	if col.Check != "" {
+		s.WriteString(fmt.Sprintf(" check (%s)", col.Check))
+	}
+	if commenter, ok := dialect.(ColumnCommenter); ok && col.Comment != "" {
+		s.WriteString(commenter.ColumnCommentClause(col.Comment))
+	}
+	return s.String()
+}
+
+// sqlForComments returns the statements storing the comments of the
+// columns, for dialects that do not store them in create table.
+func (t *TableMap) sqlForComments() []string {
+	commenter, ok := t.dbmap.Dialect.(ColumnCommenter)
+	if !ok {
+		return nil
+	}
+	var stmts []string
+	for _, col := range t.Columns {
+		if col.Transient || col.Comment == "" {
+			continue
+		}
+		table := t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)
+		if stmt := commenter.CommentOnColumn(table, t.dbmap.Dialect.QuoteField(col.ColumnName), col.Comment); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// addTagIndexes adds the indexes declared with the index and uniqueindex
+// tag options.  The fields sharing a name make up one index, in field
+// order.  Unnamed indexes are named <table>_<column>_idx.
+func (t *TableMap) addTagIndexes() {
+	var indexNames []string
+	indexCols := map[string][]string{}
+	indexUnique := map[string]bool{}
+	for _, col := range t.Columns {
+		for _, index := range col.tagIndexes {
+			name := index.name
+			if name == "" {
+				name = t.TableName + "_" + col.ColumnName + "_idx"
+			}
+			if _, ok := indexCols[name]; !ok {
+				indexNames = append(indexNames, name)
+			}
+			indexCols[name] = append(indexCols[name], col.ColumnName)
+			indexUnique[name] = indexUnique[name] || index.unique
+		}
+	}
+	for _, name := range indexNames {
+		t.AddIndex(name, "", indexCols[name]).SetUnique(indexUnique[name])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sync/atomic"
 )
 
 // CustomScanner binds a database column value to a Go type
@@ -26,6 +27,29 @@ func (me CustomScanner) Bind() error {
 	return me.Binder(me.Holder, me.Target)
 }
 
+// planCache holds a bindPlan generated on first use.  It is safe for
+// concurrent use; goroutines racing to generate the plan store the same
+// plan.
+type planCache struct {
+	plan atomic.Pointer[bindPlan]
+}
+
+// load returns the cached plan, or an empty plan if there is none.
+func (c *planCache) load() bindPlan {
+	if plan := c.plan.Load(); plan != nil {
+		return *plan
+	}
+	return bindPlan{}
+}
+
+func (c *planCache) store(plan bindPlan) {
+	c.plan.Store(&plan)
+}
+
+func (c *planCache) reset() {
+	c.plan.Store(nil)
+}
+
 type bindPlan struct {
 	query             string
 	argFields         []string
@@ -33,10 +57,51 @@ type bindPlan struct {
 	versField         string
 	autoIncrIdx       int
 	autoIncrFieldName string
+
+	// Pieces of an insert plan, kept so that multi-row inserts can be
+	// generated from the same plan.  An empty insertValues entry stands for
+	// a bind variable, anything else is written to the statement verbatim.
+	insertPrefix string
+	insertValues []string
+	insertSuffix string
+
+	// Query and fields used to read back the generated key and version of
+	// a row after an upsert.
+	fetchQuery  string
+	fetchFields []string
+
+	// Constant arguments of the conditions following the fields, such as
+	// the value of a bool soft delete marker of rows that are not deleted.
+	condArgs []interface{}
+
+	// Which of the argFields and keyFields are bound to sensitive
+	// columns, or nil if none are.
+	sensitive    []bool
+	keySensitive []bool
+}
+
+// insertRow returns the parenthesized values of one row of an insert plan,
+// numbering its bind variables from offset.
+func (plan bindPlan) insertRow(dialect Dialect, offset int) string {
+	s := bytes.Buffer{}
+	s.WriteString("(")
+	for i, value := range plan.insertValues {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		if value == "" {
+			s.WriteString(dialect.BindVar(offset))
+			offset++
+		} else {
+			s.WriteString(value)
+		}
+	}
+	s.WriteString(")")
+	return s.String()
 }
 
 func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter) (bindInstance, error) {
-	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField}
+	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField, sensitive: plan.sensitive}
 	if plan.versField != "" {
 		bi.existingVersion = elem.FieldByName(plan.versField).Int()
 	}
@@ -74,6 +139,7 @@ func (plan bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter)
 		}
 		bi.keys = append(bi.keys, val)
 	}
+	bi.args = append(bi.args, plan.condArgs...)
 
 	return bi, nil
 }
@@ -86,45 +152,75 @@ type bindInstance struct {
 	versField         string
 	autoIncrIdx       int
 	autoIncrFieldName string
+	sensitive         []bool
 }
 
 func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
-	plan := t.insertPlan
+	plan := t.insertBindPlan()
+	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+}
+
+// bindInsertBatch binds all elems to a single multi-row insert statement.
+// The auto-increment fields are not bound; see DbMap.InsertBatch.
+func (t *TableMap) bindInsertBatch(elems []reflect.Value) (bindInstance, error) {
+	plan := t.insertBindPlan()
+
+	s := bytes.Buffer{}
+	s.WriteString(plan.insertPrefix)
+	bi := bindInstance{autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName}
+	for i, elem := range elems {
+		rowBi, err := plan.createBindInstance(elem, t.dbmap.TypeConverter)
+		if err != nil {
+			return bindInstance{}, err
+		}
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(plan.insertRow(t.dbmap.Dialect, len(bi.args)))
+		if plan.sensitive != nil {
+			bi.sensitive = append(bi.sensitive, plan.sensitive...)
+		}
+		bi.args = append(bi.args, rowBi.args...)
+	}
+	s.WriteString(plan.insertSuffix)
+
+	bi.query = s.String()
+	return bi, nil
+}
+
+func (t *TableMap) insertBindPlan() bindPlan {
+	plan := t.insertPlan.load()
 	if plan.query == "" {
 		plan.autoIncrIdx = -1
 
 		s := bytes.Buffer{}
-		s2 := bytes.Buffer{}
 		s.WriteString(fmt.Sprintf("insert into %s (", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
 
-		x := 0
 		first := true
 		for y := range t.Columns {
 			col := t.Columns[y]
 			if !(col.isAutoIncr && t.dbmap.Dialect.AutoIncrBindValue() == "") {
-				if !col.Transient {
+				if !col.Transient && !col.ReadOnly {
 					if !first {
 						s.WriteString(",")
-						s2.WriteString(",")
 					}
 					s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
 
 					if col.isAutoIncr {
-						s2.WriteString(t.dbmap.Dialect.AutoIncrBindValue())
+						plan.insertValues = append(plan.insertValues, t.dbmap.Dialect.AutoIncrBindValue())
 						plan.autoIncrIdx = y
 						plan.autoIncrFieldName = col.fieldName
 					} else {
 						if col.DefaultValue == "" {
-							s2.WriteString(t.dbmap.Dialect.BindVar(x))
+							plan.insertValues = append(plan.insertValues, "")
 							if col == t.version {
 								plan.versField = col.fieldName
 								plan.argFields = append(plan.argFields, versFieldConst)
 							} else {
 								plan.argFields = append(plan.argFields, col.fieldName)
 							}
-							x++
 						} else {
-							s2.WriteString(col.DefaultValue)
+							plan.insertValues = append(plan.insertValues, col.DefaultValue)
 						}
 					}
 					first = false
@@ -134,23 +230,29 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 				plan.autoIncrFieldName = col.fieldName
 			}
 		}
-		s.WriteString(") values (")
-		s.WriteString(s2.String())
-		s.WriteString(")")
+		s.WriteString(") values ")
+		plan.insertPrefix = s.String()
+
 		if plan.autoIncrIdx > -1 {
-			s.WriteString(t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx]))
+			plan.insertSuffix = t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx])
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+		plan.insertSuffix += t.dbmap.Dialect.QuerySuffix()
 
-		plan.query = s.String()
-		t.insertPlan = plan
+		plan.query = plan.insertPrefix + plan.insertRow(t.dbmap.Dialect, 0) + plan.insertSuffix
+		plan.sensitive = t.sensitiveArgs(plan.argFields)
+		t.insertPlan.store(plan)
 	}
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return plan
 }
 
 func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
-	plan := t.updatePlan
+	plan := t.updateBindPlan()
+	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+}
+
+func (t *TableMap) updateBindPlan() bindPlan {
+	plan := t.updatePlan.load()
 	if plan.query == "" {
 
 		s := bytes.Buffer{}
@@ -159,7 +261,7 @@ func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
 
 		for y := range t.Columns {
 			col := t.Columns[y]
-			if !col.isAutoIncr && !col.Transient {
+			if !col.isAutoIncr && !col.Transient && !col.ReadOnly && col != t.created {
 				if x > 0 {
 					s.WriteString(", ")
 				}
@@ -201,14 +303,20 @@ func (t *TableMap) bindUpdate(elem reflect.Value) (bindInstance, error) {
 		s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
 		plan.query = s.String()
-		t.updatePlan = plan
+		plan.sensitive = t.sensitiveArgs(plan.argFields)
+		t.updatePlan.store(plan)
 	}
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return plan
 }
 
 func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
-	plan := t.deletePlan
+	plan := t.deleteBindPlan()
+	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+}
+
+func (t *TableMap) deleteBindPlan() bindPlan {
+	plan := t.deletePlan.load()
 	if plan.query == "" {
 
 		s := bytes.Buffer{}
@@ -247,14 +355,22 @@ func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
 		s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
 		plan.query = s.String()
-		t.deletePlan = plan
+		plan.sensitive = t.sensitiveArgs(plan.argFields)
+		t.deletePlan.store(plan)
 	}
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return plan
 }
 
-func (t *TableMap) bindGet() bindPlan {
-	plan := t.getPlan
+// bindGet returns the plan selecting a row by its keys.  Soft deleted rows
+// are only selected if withDeleted is true; their keys are followed by
+// the plan's condArgs otherwise.
+func (t *TableMap) bindGet(withDeleted bool) bindPlan {
+	withDeleted = withDeleted || t.softDelete == nil
+	plan := t.getPlan.load()
+	if withDeleted {
+		plan = t.getAllPlan.load()
+	}
 	if plan.query == "" {
 
 		s := bytes.Buffer{}
@@ -285,11 +401,137 @@ func (t *TableMap) bindGet() bindPlan {
 
 			plan.keyFields = append(plan.keyFields, col.fieldName)
 		}
+		if !withDeleted {
+			cond, args := t.notDeletedCond(t.dbmap.Dialect.BindVar(len(t.keys)))
+			s.WriteString(" and " + cond)
+			plan.condArgs = args
+		}
 		s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
 		plan.query = s.String()
-		t.getPlan = plan
+		plan.keySensitive = t.sensitiveArgs(plan.keyFields)
+		if withDeleted {
+			t.getAllPlan.store(plan)
+		} else {
+			t.getPlan.store(plan)
+		}
 	}
 
 	return plan
 }
+
+// upsertConflictCols returns the columns an upsert detects existing rows
+// by: the primary key unless it is auto-incremented, otherwise the first
+// SetUniqueTogether constraint or unique column.
+func (t *TableMap) upsertConflictCols() ([]*ColumnMap, error) {
+	if len(t.keys) > 0 && !t.keys[0].isAutoIncr {
+		return t.keys, nil
+	}
+	if len(t.uniqueTogether) > 0 {
+		cols := make([]*ColumnMap, 0, len(t.uniqueTogether[0]))
+		for _, name := range t.uniqueTogether[0] {
+			cols = append(cols, t.ColMap(name))
+		}
+		return cols, nil
+	}
+	for _, col := range t.Columns {
+		if col.Unique && !col.Transient {
+			return []*ColumnMap{col}, nil
+		}
+	}
+	return nil, fmt.Errorf("gorp: Upsert requires a non auto-increment primary key or a unique constraint on table: %s", t.TableName)
+}
+
+func (t *TableMap) upsertBindPlan() (bindPlan, error) {
+	plan := t.upsertPlan.load()
+	if plan.query == "" {
+		upserter, ok := t.dbmap.Dialect.(UpsertDialect)
+		if !ok {
+			return bindPlan{}, fmt.Errorf("gorp: Upsert is not supported by dialect %T", t.dbmap.Dialect)
+		}
+		conflict, err := t.upsertConflictCols()
+		if err != nil {
+			return bindPlan{}, err
+		}
+
+		var (
+			cols   []*ColumnMap
+			values []string
+			update []*ColumnMap
+			fetch  []*ColumnMap
+		)
+		x := 0
+		for _, col := range t.Columns {
+			if col.Transient || col.ReadOnly {
+				continue
+			}
+			if col.isAutoIncr {
+				fetch = append(fetch, col)
+				continue
+			}
+			cols = append(cols, col)
+			if col.DefaultValue == "" {
+				values = append(values, t.dbmap.Dialect.BindVar(x))
+				if col == t.version {
+					plan.versField = col.fieldName
+					plan.argFields = append(plan.argFields, versFieldConst)
+				} else {
+					plan.argFields = append(plan.argFields, col.fieldName)
+				}
+				x++
+			} else {
+				values = append(values, col.DefaultValue)
+			}
+			isConflict := col == t.created
+			for _, c := range conflict {
+				if c == col {
+					isConflict = true
+					break
+				}
+			}
+			if !isConflict {
+				update = append(update, col)
+			}
+		}
+		if t.version != nil {
+			fetch = append(fetch, t.version)
+		}
+		if t.created != nil {
+			fetch = append(fetch, t.created)
+		}
+
+		plan.query = upserter.UpsertQuery(t.SchemaName, t.TableName, cols, values, conflict, update, t.version)
+
+		if len(fetch) > 0 {
+			s := bytes.Buffer{}
+			s.WriteString("select ")
+			for i, col := range fetch {
+				if i > 0 {
+					s.WriteString(",")
+				}
+				s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+				plan.fetchFields = append(plan.fetchFields, col.fieldName)
+			}
+			s.WriteString(" from ")
+			s.WriteString(t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName))
+			s.WriteString(" where ")
+			for i, col := range conflict {
+				if i > 0 {
+					s.WriteString(" and ")
+				}
+				s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+				s.WriteString("=")
+				s.WriteString(t.dbmap.Dialect.BindVar(i))
+				plan.keyFields = append(plan.keyFields, col.fieldName)
+			}
+			s.WriteString(t.dbmap.Dialect.QuerySuffix())
+			plan.fetchQuery = s.String()
+		}
+
+		plan.sensitive = t.sensitiveArgs(plan.argFields)
+		plan.keySensitive = t.sensitiveArgs(plan.keyFields)
+		t.upsertPlan.store(plan)
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,118 @@
+package gorp
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Clock provides the current time to the created and updated timestamp
+// columns, and to soft delete columns holding the time of deletion.
+// Tests can set DbMap.Clock to a fixed clock to get predictable
+// timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// The ClockFunc type is an adapter to allow the use of ordinary functions
+// as a Clock.
+type ClockFunc func() time.Time
+
+// Now calls f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// now returns the current time of the DbMap's clock.
+func (m *DbMap) now() time.Time {
+	if m.Clock != nil {
+		return m.Clock.Now()
+	}
+	return time.Now()
+}
+
+// isTimestampType reports whether a created or updated column may have
+// type t.
+func isTimestampType(t reflect.Type) bool {
+	switch t {
+	case timeType, reflect.PtrTo(timeType), nullTimeType, sqlNullTime:
+		return true
+	}
+	return false
+}
+
+func (t *TableMap) timestampCol(field, option string) *ColumnMap {
+	c := t.ColMap(field)
+	f, _ := t.gotype.FieldByName(c.fieldName)
+	if !isTimestampType(f.Type) {
+		panic(fmt.Sprintf("gorp: %s field %s must be a time.Time, *time.Time, NullTime or sql.NullTime, not %v",
+			option, field, f.Type))
+	}
+	return c
+}
+
+// SetCreatedCol sets the column holding the time a row was inserted.
+// Insert and Upsert set it from the DbMap's Clock before the PreInsert
+// hook runs, and Update never changes it.  The field must be a time.Time,
+// *time.Time, NullTime or sql.NullTime.  The "created" tag option has the
+// same effect:
+//
+//	CreatedAt time.Time `db:"created_at,created"`
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+//
+// Panics if the struct does not contain a field matching this name, or if
+// the field has another type.
+func (t *TableMap) SetCreatedCol(field string) *ColumnMap {
+	t.checkFrozen("SetCreatedCol")
+	t.created = t.timestampCol(field, "created")
+	t.ResetSql()
+	return t.created
+}
+
+// SetUpdatedCol sets the column holding the time a row was last inserted
+// or updated.  Insert, Update and Upsert set it from the DbMap's Clock
+// before the PreInsert or PreUpdate hook runs.  The field must be a
+// time.Time, *time.Time, NullTime or sql.NullTime.  The "updated" tag
+// option has the same effect.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+//
+// Panics if the struct does not contain a field matching this name, or if
+// the field has another type.
+func (t *TableMap) SetUpdatedCol(field string) *ColumnMap {
+	t.checkFrozen("SetUpdatedCol")
+	t.updated = t.timestampCol(field, "updated")
+	t.ResetSql()
+	return t.updated
+}
+
+// setTimestamps sets the created column of elem, if inserted is true,
+// and its updated column to the current time.
+func (t *TableMap) setTimestamps(elem reflect.Value, inserted bool) {
+	if t.updated == nil && (t.created == nil || !inserted) {
+		return
+	}
+	now := t.dbmap.now()
+	if t.created != nil && inserted {
+		setTime(elem.FieldByName(t.created.fieldName), now)
+	}
+	if t.updated != nil {
+		setTime(elem.FieldByName(t.updated.fieldName), now)
+	}
+}
+
+// setTime sets a field of one of the timestamp types to now.
+func setTime(f reflect.Value, now time.Time) {
+	switch f.Type() {
+	case timeType:
+		f.Set(reflect.ValueOf(now))
+	case nullTimeType:
+		f.Set(reflect.ValueOf(NullTime{Time: now, Valid: true}))
+	case sqlNullTime:
+		f.Set(reflect.ValueOf(sql.NullTime{Time: now, Valid: true}))
+	default:
+		f.Set(reflect.ValueOf(&now))
+	}
+}
@@ -1,7 +1,9 @@
 package gorp
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"time"
 )
 
@@ -10,9 +12,110 @@ import (
 // of that transaction.  Transactions should be terminated with
 // a call to Commit() or Rollback()
 type Transaction struct {
-	dbmap  *DbMap
-	tx     *sql.Tx
+	dbmap         *DbMap
+	tx            *sql.Tx
+	id            uint64
+	ctx           context.Context
+	withDeleted   bool
+	stmtTable     *TableMap
+	stmtOp        string
+	stmtSensitive []bool
+
+	// Connection of its own, set up by a TxOptionsDialect, and the
+	// statements restoring it once the transaction ended.  tx is nil if a
+	// TxBeginner dialect began the transaction on conn.
+	conn  *sql.Conn
+	reset []string
+
+	// Name of the savepoint of a transaction begun with Transaction.Begin.
+	savepoint string
+
+	// State shared with the copies of the transaction returned by
+	// WithContext, WithDeleted and the like.
+	state *txState
+}
+
+// txState is shared by a transaction and its copies, so that ending any
+// of them ends them all.
+type txState struct {
 	closed bool
+
+	// Functions registered with OnCommit and OnRollback, kept until the
+	// outcome of the transaction is known.
+	onCommit   []func()
+	onRollback []func()
+
+	// State of the transaction a nested transaction was begun in, and the
+	// number of savepoints named by Begin, shared with it.
+	parent     *txState
+	savepoints *int
+}
+
+// txConn is what the statements of a transaction run on: its sql.Tx, or
+// the sql.Conn a TxBeginner dialect began it on.
+type txConn interface {
+	executor
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+func (t *Transaction) txConn() txConn {
+	if t.tx == nil {
+		return t.conn
+	}
+	return t.tx
+}
+
+// end commits or rolls back the database transaction, and returns its
+// connection of its own to the pool.
+func (t *Transaction) end(commit bool) error {
+	if t.tx == nil {
+		return t.endConn(commit)
+	}
+	var err error
+	if commit {
+		err = t.tx.Commit()
+	} else {
+		err = t.tx.Rollback()
+	}
+	if t.conn != nil {
+		t.dbmap.releaseConn(t.conn, t.id, t.reset)
+	}
+	return err
+}
+
+// endConn ends a transaction begun with a statement of the dialect by
+// running "commit" or "rollback" on its connection.  A failed commit,
+// which leaves the transaction open when the database is busy, is rolled
+// back.  The connection is discarded if the transaction could not be
+// ended.
+func (t *Transaction) endConn(commit bool) error {
+	ctx := context.Background()
+	var err error
+	if commit {
+		_, err = t.conn.ExecContext(ctx, "commit;")
+		if err == nil {
+			t.dbmap.releaseConn(t.conn, t.id, t.reset)
+			return nil
+		}
+	}
+	if _, rerr := t.conn.ExecContext(ctx, "rollback;"); rerr != nil {
+		closeConn(t.conn, true)
+		if err == nil {
+			err = rerr
+		}
+		return err
+	}
+	t.dbmap.releaseConn(t.conn, t.id, t.reset)
+	return err
+}
+
+// newTxState returns the state of a transaction nested in the one of
+// parent, or of an outermost transaction if parent is nil.
+func newTxState(parent *txState) *txState {
+	if parent == nil {
+		return &txState{savepoints: new(int)}
+	}
+	return &txState{parent: parent, savepoints: parent.savepoints}
 }
 
 // Insert has the same behavior as DbMap.Insert(), but runs in a transaction.
@@ -20,6 +123,18 @@ func (t *Transaction) Insert(list ...interface{}) error {
 	return insert(t.dbmap, t, list...)
 }
 
+// InsertBatch has the same behavior as DbMap.InsertBatch(), but runs in a
+// transaction.
+func (t *Transaction) InsertBatch(list ...interface{}) error {
+	return insertBatch(t.dbmap, t, list...)
+}
+
+// Upsert has the same behavior as DbMap.Upsert(), but runs in a
+// transaction.
+func (t *Transaction) Upsert(list ...interface{}) error {
+	return upsert(t.dbmap, t, list...)
+}
+
 // Update had the same behavior as DbMap.Update(), but runs in a transaction.
 func (t *Transaction) Update(list ...interface{}) (int64, error) {
 	return update(t.dbmap, t, list...)
@@ -30,6 +145,18 @@ func (t *Transaction) Delete(list ...interface{}) (int64, error) {
 	return delete(t.dbmap, t, list...)
 }
 
+// HardDelete has the same behavior as DbMap.HardDelete(), but runs in a
+// transaction.
+func (t *Transaction) HardDelete(list ...interface{}) (int64, error) {
+	return hardDelete(t.dbmap, t, list...)
+}
+
+// Restore has the same behavior as DbMap.Restore(), but runs in a
+// transaction.
+func (t *Transaction) Restore(list ...interface{}) (int64, error) {
+	return restore(t.dbmap, t, list...)
+}
+
 // Get has the same behavior as DbMap.Get(), but runs in a transaction.
 func (t *Transaction) Get(i interface{}, keys ...interface{}) (interface{}, error) {
 	return get(t.dbmap, t, i, keys...)
@@ -40,11 +167,29 @@ func (t *Transaction) Select(i interface{}, query string, args ...interface{}) (
 	return hookedselect(t.dbmap, t, i, query, args...)
 }
 
+// Query has the same behavior as DbMap.Query(), but runs in a transaction.
+func (t *Transaction) Query(i interface{}) *Query {
+	return newQuery(t.dbmap, t, i)
+}
+
+// Preload has the same behavior as DbMap.Preload(), but runs in a
+// transaction.
+func (t *Transaction) Preload(list interface{}, relations ...string) error {
+	return preload(t.dbmap, t, list, relations)
+}
+
+// SelectIter has the same behavior as DbMap.SelectIter(), but runs in a
+// transaction.  The iterator must be closed before the transaction runs
+// other statements.
+func (t *Transaction) SelectIter(i interface{}, query string, args ...interface{}) (*SelectIterator, error) {
+	return selectIter(t.dbmap, t, i, query, args...)
+}
+
 // Exec has the same behavior as DbMap.Exec(), but runs in a transaction.
 func (t *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.trace(now, query, t.dbmap.logArgs(query, t.stmtSensitive, args)...)
 	}
 	return exec(t, query, args...)
 }
@@ -84,34 +229,274 @@ func (t *Transaction) SelectOne(holder interface{}, query string, args ...interf
 	return SelectOne(t.dbmap, t, holder, query, args...)
 }
 
-// Commit commits the underlying database transaction.
+// InsertContext has the same behavior as Insert, but runs with ctx.
+func (t *Transaction) InsertContext(ctx context.Context, list ...interface{}) error {
+	return t.WithContext(ctx).Insert(list...)
+}
+
+// InsertBatchContext has the same behavior as InsertBatch, but runs with ctx.
+func (t *Transaction) InsertBatchContext(ctx context.Context, list ...interface{}) error {
+	return insertBatch(t.dbmap, t.WithContext(ctx), list...)
+}
+
+// UpsertContext has the same behavior as Upsert, but runs with ctx.
+func (t *Transaction) UpsertContext(ctx context.Context, list ...interface{}) error {
+	return upsert(t.dbmap, t.WithContext(ctx), list...)
+}
+
+// UpdateContext has the same behavior as Update, but runs with ctx.
+func (t *Transaction) UpdateContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return t.WithContext(ctx).Update(list...)
+}
+
+// DeleteContext has the same behavior as Delete, but runs with ctx.
+func (t *Transaction) DeleteContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return t.WithContext(ctx).Delete(list...)
+}
+
+// HardDeleteContext has the same behavior as HardDelete, but runs with ctx.
+func (t *Transaction) HardDeleteContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return hardDelete(t.dbmap, t.WithContext(ctx), list...)
+}
+
+// RestoreContext has the same behavior as Restore, but runs with ctx.
+func (t *Transaction) RestoreContext(ctx context.Context, list ...interface{}) (int64, error) {
+	return restore(t.dbmap, t.WithContext(ctx), list...)
+}
+
+// GetContext has the same behavior as Get, but runs with ctx.
+func (t *Transaction) GetContext(ctx context.Context, i interface{}, keys ...interface{}) (interface{}, error) {
+	return t.WithContext(ctx).Get(i, keys...)
+}
+
+// SelectContext has the same behavior as Select, but runs with ctx.
+func (t *Transaction) SelectContext(ctx context.Context, i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return t.WithContext(ctx).Select(i, query, args...)
+}
+
+// SelectIterContext has the same behavior as SelectIter, but runs with ctx.
+func (t *Transaction) SelectIterContext(ctx context.Context, i interface{}, query string, args ...interface{}) (*SelectIterator, error) {
+	return t.WithContext(ctx).SelectIter(i, query, args...)
+}
+
+// ExecContext has the same behavior as Exec, but runs with ctx.
+func (t *Transaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.WithContext(ctx).Exec(query, args...)
+}
+
+// SelectIntContext has the same behavior as SelectInt, but runs with ctx.
+func (t *Transaction) SelectIntContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return t.WithContext(ctx).SelectInt(query, args...)
+}
+
+// SelectNullIntContext has the same behavior as SelectNullInt, but runs with ctx.
+func (t *Transaction) SelectNullIntContext(ctx context.Context, query string, args ...interface{}) (sql.NullInt64, error) {
+	return t.WithContext(ctx).SelectNullInt(query, args...)
+}
+
+// SelectFloatContext has the same behavior as SelectFloat, but runs with ctx.
+func (t *Transaction) SelectFloatContext(ctx context.Context, query string, args ...interface{}) (float64, error) {
+	return t.WithContext(ctx).SelectFloat(query, args...)
+}
+
+// SelectNullFloatContext has the same behavior as SelectNullFloat, but runs with ctx.
+func (t *Transaction) SelectNullFloatContext(ctx context.Context, query string, args ...interface{}) (sql.NullFloat64, error) {
+	return t.WithContext(ctx).SelectNullFloat(query, args...)
+}
+
+// SelectStrContext has the same behavior as SelectStr, but runs with ctx.
+func (t *Transaction) SelectStrContext(ctx context.Context, query string, args ...interface{}) (string, error) {
+	return t.WithContext(ctx).SelectStr(query, args...)
+}
+
+// SelectNullStrContext has the same behavior as SelectNullStr, but runs with ctx.
+func (t *Transaction) SelectNullStrContext(ctx context.Context, query string, args ...interface{}) (sql.NullString, error) {
+	return t.WithContext(ctx).SelectNullStr(query, args...)
+}
+
+// SelectOneContext has the same behavior as SelectOne, but runs with ctx.
+func (t *Transaction) SelectOneContext(ctx context.Context, holder interface{}, query string, args ...interface{}) error {
+	return t.WithContext(ctx).SelectOne(holder, query, args...)
+}
+
+// WithContext returns a shallow copy of the Transaction that runs every
+// statement with ctx.  The copy shares the underlying database transaction
+// with t, so committing or rolling back either of them ends it for both.
+func (t *Transaction) WithContext(ctx context.Context) SqlExecutor {
+	dup := &Transaction{}
+	*dup = *t
+	dup.ctx = ctx
+	return dup
+}
+
+// Primary returns t, since transactions always run on the primary.
+func (t *Transaction) Primary() SqlExecutor {
+	return t
+}
+
+// WithDeleted returns a shallow copy of the Transaction whose Get and
+// Query include soft deleted rows.  See TableMap.SetSoftDeleteCol.
+func (t *Transaction) WithDeleted() SqlExecutor {
+	dup := &Transaction{}
+	*dup = *t
+	dup.withDeleted = true
+	return dup
+}
+
+// Context returns the context statements are run with.  This is the
+// context passed to DbMap.BeginTx, or context.Background() for
+// transactions started with DbMap.Begin.
+func (t *Transaction) Context() context.Context {
+	if t.ctx != nil {
+		return t.ctx
+	}
+	return context.Background()
+}
+
+// Begin begins a transaction nested in t, backed by a savepoint named
+// after how many t and its nested transactions have begun.  Commit
+// releases the savepoint and Rollback rolls back to it, leaving t open.
+//
+// Since DbMap.Begin begins a transaction of its own, code written against
+// SqlExecutor can begin, commit and roll back a transaction whether or not
+// it runs in one:
+//
+//	tx, err := exec.Begin()
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//	if err := tx.Insert(inv); err != nil {
+//		return err
+//	}
+//	return tx.Commit()
+func (t *Transaction) Begin() (*Transaction, error) {
+	if t.state.closed {
+		return nil, sql.ErrTxDone
+	}
+	*t.state.savepoints++
+	child := &Transaction{
+		dbmap:     t.dbmap,
+		tx:        t.tx,
+		conn:      t.conn,
+		id:        t.id,
+		ctx:       t.ctx,
+		savepoint: fmt.Sprintf("gorp_savepoint_%d", *t.state.savepoints),
+		state:     newTxState(t.state),
+	}
+	if err := child.Savepoint(child.savepoint); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// Commit commits the underlying database transaction, or releases the
+// savepoint of a transaction begun with Transaction.Begin.
 func (t *Transaction) Commit() error {
-	if !t.closed {
-		t.closed = true
+	if t.savepoint != "" && !t.state.closed {
+		t.state.closed = true
+		err := t.ReleaseSavepoint(t.savepoint)
+		t.state.released()
+		return err
+	}
+	if !t.state.closed {
+		t.state.closed = true
 		if t.dbmap.logger != nil {
 			now := time.Now()
 			defer t.dbmap.trace(now, "commit;")
 		}
-		return t.tx.Commit()
+		started := time.Now()
+		err := t.dbmap.classifyError(t.end(true))
+		if t.dbmap.structLogger != nil {
+			t.dbmap.logEvent(t.Context(), t.id, "commit", time.Since(started), err, nil)
+		}
+		if err == nil {
+			t.state.committed()
+		} else {
+			t.state.rolledBack()
+		}
+		return err
 	}
 
 	return sql.ErrTxDone
 }
 
-// Rollback rolls back the underlying database transaction.
+// Rollback rolls back the underlying database transaction, or rolls back
+// to the savepoint of a transaction begun with Transaction.Begin.
 func (t *Transaction) Rollback() error {
-	if !t.closed {
-		t.closed = true
+	if t.savepoint != "" && !t.state.closed {
+		t.state.closed = true
+		err := t.RollbackToSavepoint(t.savepoint)
+		if err != nil {
+			// The changes stay in the outer transaction, which decides.
+			t.state.released()
+			return err
+		}
+		t.state.rolledBack()
+		return t.ReleaseSavepoint(t.savepoint)
+	}
+	if !t.state.closed {
+		t.state.closed = true
 		if t.dbmap.logger != nil {
 			now := time.Now()
 			defer t.dbmap.trace(now, "rollback;")
 		}
-		return t.tx.Rollback()
+		started := time.Now()
+		err := t.end(false)
+		if t.dbmap.structLogger != nil {
+			t.dbmap.logEvent(t.Context(), t.id, "rollback", time.Since(started), err, nil)
+		}
+		t.state.rolledBack()
+		return err
 	}
 
 	return sql.ErrTxDone
 }
 
+// OnCommit registers fn to run once t is committed, after Commit returns
+// from the database.  Hooks reach it through their SqlExecutor, so side
+// effects such as sending mail do not happen for rows that are rolled
+// back.  Functions registered in a transaction begun with
+// Transaction.Begin wait for the outermost transaction to commit.
+// Functions run in the order they were registered.
+func (t *Transaction) OnCommit(fn func()) {
+	t.state.onCommit = append(t.state.onCommit, fn)
+}
+
+// OnRollback registers fn to run once t is rolled back, or fails to
+// commit.  Functions registered in a transaction begun with
+// Transaction.Begin also run when the transaction is rolled back to its
+// savepoint.  Functions run in the order they were registered.
+func (t *Transaction) OnRollback(fn func()) {
+	t.state.onRollback = append(t.state.onRollback, fn)
+}
+
+// committed runs the OnCommit functions.
+func (c *txState) committed() {
+	fns := c.onCommit
+	c.onCommit, c.onRollback = nil, nil
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// rolledBack runs the OnRollback functions.
+func (c *txState) rolledBack() {
+	fns := c.onRollback
+	c.onCommit, c.onRollback = nil, nil
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// released hands the functions of a nested transaction to the transaction
+// it was begun in, whose outcome decides which of them run.
+func (c *txState) released() {
+	c.parent.onCommit = append(c.parent.onCommit, c.onCommit...)
+	c.parent.onRollback = append(c.parent.onRollback, c.onRollback...)
+	c.onCommit, c.onRollback = nil, nil
+}
+
 // Savepoint creates a savepoint with the given name. The name is interpolated
 // directly into the SQL SAVEPOINT statement, so you must sanitize it if it is
 // derived from user input.
@@ -121,8 +506,7 @@ func (t *Transaction) Savepoint(name string) error {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
 	}
-	_, err := t.tx.Exec(query)
-	return err
+	return runStatement(t, ExecStatement, query, nil).Err
 }
 
 // RollbackToSavepoint rolls back to the savepoint with the given name. The
@@ -134,8 +518,7 @@ func (t *Transaction) RollbackToSavepoint(savepoint string) error {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
 	}
-	_, err := t.tx.Exec(query)
-	return err
+	return runStatement(t, ExecStatement, query, nil).Err
 }
 
 // ReleaseSavepint releases the savepoint with the given name. The name is
@@ -147,8 +530,7 @@ func (t *Transaction) ReleaseSavepoint(savepoint string) error {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
 	}
-	_, err := t.tx.Exec(query)
-	return err
+	return runStatement(t, ExecStatement, query, nil).Err
 }
 
 // Prepare has the same behavior as DbMap.Prepare(), but runs in a transaction.
@@ -157,21 +539,32 @@ func (t *Transaction) Prepare(query string) (*sql.Stmt, error) {
 		now := time.Now()
 		defer t.dbmap.trace(now, query, nil)
 	}
-	return t.tx.Prepare(query)
+	return t.txConn().PrepareContext(t.Context(), query)
+}
+
+// PrepareContext has the same behavior as DbMap.PrepareContext(), but runs
+// in a transaction.
+func (t *Transaction) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if t.dbmap.logger != nil {
+		now := time.Now()
+		defer t.dbmap.trace(now, query, nil)
+	}
+	return t.txConn().PrepareContext(ctx, query)
 }
 
-func (t *Transaction) queryRow(query string, args ...interface{}) *sql.Row {
+func (t *Transaction) queryRow(query string, args ...interface{}) row {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.trace(now, query, t.dbmap.logArgs(query, t.stmtSensitive, args)...)
 	}
-	return t.tx.QueryRow(query, args...)
+	return t.dbmap.resultRow(runStatement(t, QueryRowStatement, query, args))
 }
 
 func (t *Transaction) query(query string, args ...interface{}) (*sql.Rows, error) {
 	if t.dbmap.logger != nil {
 		now := time.Now()
-		defer t.dbmap.trace(now, query, args...)
+		defer t.dbmap.trace(now, query, t.dbmap.logArgs(query, t.stmtSensitive, args)...)
 	}
-	return t.tx.Query(query, args...)
+	res := runStatement(t, QueryStatement, query, args)
+	return res.Rows, res.Err
 }
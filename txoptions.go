@@ -0,0 +1,106 @@
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+)
+
+// isolationName returns the name of level in "set transaction isolation
+// level" statements, or "" if it has none.
+func isolationName(level sql.IsolationLevel) string {
+	switch level {
+	case sql.LevelReadUncommitted:
+		return "read uncommitted"
+	case sql.LevelReadCommitted:
+		return "read committed"
+	case sql.LevelRepeatableRead:
+		return "repeatable read"
+	case sql.LevelSnapshot:
+		return "snapshot"
+	case sql.LevelSerializable:
+		return "serializable"
+	}
+	return ""
+}
+
+// beginTx begins the database transaction id with opts.  If the dialect
+// is a TxOptionsDialect and opts are not the defaults, the transaction
+// runs on a connection of its own, set up with the dialect's statements;
+// the connection and the statements restoring it are returned with it.
+// The sql.Tx is nil if a TxBeginner dialect began the transaction with a
+// statement of its own.
+func (m *DbMap) beginTx(ctx context.Context, id uint64, opts *sql.TxOptions) (*sql.Tx, *sql.Conn, []string, error) {
+	d, ok := m.Dialect.(TxOptionsDialect)
+	if !ok || opts == nil || (opts.Isolation == sql.LevelDefault && !opts.ReadOnly) {
+		tx, err := m.Db.BeginTx(ctx, opts)
+		return tx, nil, nil, err
+	}
+
+	setup, reset, err := d.TxOptionsStatements(opts.Isolation, opts.ReadOnly)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	conn, err := m.Db.Conn(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, query := range setup {
+		err = m.execConn(ctx, conn, id, query)
+		if err != nil {
+			// The statements that succeeded may still apply.
+			closeConn(conn, true)
+			return nil, nil, nil, err
+		}
+	}
+	if b, ok := d.(TxBeginner); ok {
+		if query := b.BeginStatement(opts.Isolation, opts.ReadOnly); query != "" {
+			err = m.execConn(ctx, conn, id, query)
+			if err != nil {
+				m.releaseConn(conn, id, reset)
+				return nil, nil, nil, err
+			}
+			return nil, conn, reset, nil
+		}
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		m.releaseConn(conn, id, reset)
+		return nil, nil, nil, err
+	}
+	return tx, conn, reset, nil
+}
+
+// execConn runs a statement setting up or resetting the connection of
+// transaction id.  Like the statements of the transaction, it goes
+// through the interceptors, loggers and metrics of m.
+func (m *DbMap) execConn(ctx context.Context, conn *sql.Conn, id uint64, query string) error {
+	if m.logger != nil {
+		now := time.Now()
+		defer m.trace(now, query)
+	}
+	return m.run(conn, false, &Statement{Kind: ExecStatement, Query: query, Context: ctx, TxID: id}).Err
+}
+
+// releaseConn runs the reset statements of transaction id on conn and
+// returns it to the pool.  If they fail, the connection is discarded
+// instead, so that no other statement runs with the settings of the
+// transaction.
+func (m *DbMap) releaseConn(conn *sql.Conn, id uint64, reset []string) {
+	for _, query := range reset {
+		if err := m.execConn(context.Background(), conn, id, query); err != nil {
+			closeConn(conn, true)
+			return
+		}
+	}
+	closeConn(conn, false)
+}
+
+// closeConn returns conn to the pool, or discards it if bad is true.
+func closeConn(conn *sql.Conn, bad bool) {
+	if bad {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}